@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/symbol"
+)
+
+// goTypes are the Go types of the value types
+var goTypes = map[symbol.Keyword]string{
+	schema.DbTypeBoolean: "bool",
+	schema.DbTypeDouble:  "float64",
+	schema.DbTypeString:  "string",
+	schema.DbTypeLong:    "int64",
+	schema.DbTypeRef:     "datoms.Entity",
+	schema.DbTypeKeyword: "symbol.Keyword",
+	schema.DbTypeInstant: "time.Time",
+}
+
+// entityType is the struct of the attributes of a namespace
+type entityType struct {
+	name   string
+	fields []string
+}
+
+// generate writes the Go source of package pkg for the schema. Every ident
+// outside of the db namespaces is a keyword variable and the attributes of
+// a namespace are the fields of an entity struct.
+func generate(pkg string, s schema.Interface) ([]byte, error) {
+	var (
+		vars    []string
+		types   []*entityType
+		byNs    = make(map[string]*entityType)
+		names   = make(map[string]symbol.Keyword)
+		useTime bool
+		attrs   = make(map[symbol.Keyword]schema.Attr)
+		idents  []symbol.Keyword
+	)
+
+	for _, attr := range s.Attrs() {
+		attrs[attr.Ident] = attr
+	}
+	for _, e := range s.Idents() {
+		if ns := namespace(e.Ident); ns == "db" || strings.HasPrefix(ns, "db.") {
+			continue
+		}
+		idents = append(idents, e.Ident)
+	}
+	slices.SortFunc(idents, func(x, y symbol.Keyword) int { return strings.Compare(x.String(), y.String()) })
+
+	for _, ident := range idents {
+		name := goName(ident.String()[1:])
+		if prev, ok := names[name]; ok {
+			return nil, fmt.Errorf("datoms: %v and %v are both %v in Go", prev, ident, name)
+		}
+		names[name] = ident
+		vars = append(vars, fmt.Sprintf("%v = symbol.For(%q)", name, ident.String()))
+
+		attr, ok := attrs[ident]
+		if !ok {
+			continue // enum
+		}
+
+		ns := namespace(ident)
+		t, ok := byNs[ns]
+		if !ok {
+			t = &entityType{name: goName(ns)}
+			byNs[ns] = t
+			types = append(types, t)
+		}
+
+		valueType, _ := s.Ident(attr.ValueType)
+		goType, ok := goTypes[valueType]
+		if !ok {
+			return nil, fmt.Errorf("datoms: no Go type for %v of %v", valueType, ident)
+		}
+		useTime = useTime || valueType == schema.DbTypeInstant
+		if cardinality, _ := s.Ident(attr.Cardinality); cardinality == schema.DbCardinalityMany {
+			goType = "[]" + goType
+		}
+		_, field, _ := strings.Cut(ident.String(), "/")
+		t.fields = append(t.fields, fmt.Sprintf("%v %v %v", goName(field), goType, tag(s, attr)))
+	}
+
+	for _, t := range types {
+		if ident, ok := names[t.name]; ok {
+			return nil, fmt.Errorf("datoms: %v and the entity type of %v are both %v in Go", ident, t.name, t.name)
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by datoms-gen. DO NOT EDIT.\n\npackage %v\n\nimport (\n", pkg)
+	if useTime {
+		b.WriteString("\"time\"\n\n")
+	}
+	if 0 < len(types) {
+		b.WriteString("\"github.com/leidegre/datoms/datoms\"\n")
+	}
+	if 0 < len(vars) {
+		b.WriteString("\"github.com/leidegre/datoms/symbol\"\n")
+	}
+	b.WriteString(")\n\n")
+
+	if 0 < len(vars) {
+		b.WriteString("var (\n")
+		for _, v := range vars {
+			b.WriteString(v + "\n")
+		}
+		b.WriteString(")\n")
+	}
+	for _, t := range types {
+		fmt.Fprintf(&b, "\ntype %v struct {\ndatoms.Entity\n", t.name)
+		for _, f := range t.fields {
+			b.WriteString(f + "\n")
+		}
+		b.WriteString("}\n")
+	}
+
+	return format.Source(b.Bytes())
+}
+
+func namespace(ident symbol.Keyword) string {
+	ns, _, _ := strings.Cut(ident.String()[1:], "/")
+	return ns
+}
+
+// tag is the struct tag of an attribute, with the options that
+// schema.FromStructs reads
+func tag(s schema.Interface, attr schema.Attr) string {
+	opts := []string{attr.Ident.String()}
+	switch unique, _ := s.Ident(attr.Unique); unique {
+	case schema.DbUniqueValue:
+		opts = append(opts, "unique=value")
+	case schema.DbUniqueIdentity:
+		opts = append(opts, "unique=identity")
+	}
+	if attr.Index {
+		opts = append(opts, "index")
+	}
+	if attr.IsComponent {
+		opts = append(opts, "component")
+	}
+	if attr.NoHistory {
+		opts = append(opts, "noHistory")
+	}
+	tag := "ident:" + strconv.Quote(strings.Join(opts, ","))
+	if attr.Doc != "" {
+		tag += " doc:" + strconv.Quote(attr.Doc)
+	}
+	if strings.Contains(tag, "`") {
+		return strconv.Quote(tag)
+	}
+	return "`" + tag + "`"
+}
+
+// goName is an exported Go name like PersonStatusActive for person.status/active
+func goName(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range s {
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	name := b.String()
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "X" + name
+	}
+	return name
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const schemaEDN = `
+[{:db/id "email" :db/ident :person/email :db/valueType :db.type/string :db/cardinality :db.cardinality/one
+  :db/unique :db.unique/identity :db/doc "The email address"}
+ {:db/id "friends" :db/ident :person/friends :db/valueType :db.type/ref :db/cardinality :db.cardinality/many}
+ {:db/id "born" :db/ident :person/born :db/valueType :db.type/instant :db/cardinality :db.cardinality/one}
+ {:db/id "status" :db/ident :person/status :db/valueType :db.type/keyword :db/cardinality :db.cardinality/one}
+ {:db/id "city" :db/ident :address/zip-code :db/valueType :db.type/long :db/cardinality :db.cardinality/one}
+ {:db/id :db.part/db :db.install/attribute ["email" "friends" "born" "status" "city"]}
+ {:db/ident :person.status/active}]
+`
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "schema.edn"), filepath.Join(dir, "schema_gen.go")
+	if err := os.WriteFile(in, []byte(schemaEDN), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run("model", out, []string{in}); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), out, src, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{
+		"package model",
+		`PersonEmail        = symbol.For(":person/email")`,
+		`PersonStatusActive = symbol.For(":person.status/active")`,
+		"type Address struct",
+		"ZipCode int64 `ident:\":address/zip-code\"`",
+		"type Person struct",
+		"Email   string          `ident:\":person/email,unique=identity\" doc:\"The email address\"`",
+		"Friends []datoms.Entity `ident:\":person/friends\"`",
+		"Born    time.Time",
+		"Status  symbol.Keyword",
+	} {
+		if !strings.Contains(string(src), s) {
+			t.Errorf("expected %v in\n%s", s, src)
+		}
+	}
+}
+
+func TestGenerateConflict(t *testing.T) {
+	in := filepath.Join(t.TempDir(), "schema.edn")
+	if err := os.WriteFile(in, []byte(`[{:db/ident :order.line/item} {:db/ident :order/line-item}]`), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	db, err := load([]string{in})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := generate("model", db.Schema()); err == nil {
+		t.Fatal("expected an error for idents that are the same Go name")
+	}
+}
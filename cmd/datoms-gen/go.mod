@@ -0,0 +1,3 @@
+module github.com/leidegre/datoms/cmd/datoms-gen
+
+go 1.21
@@ -0,0 +1,78 @@
+// Command datoms-gen writes Go source for a schema, keyword variables for
+// its idents and entity structs for its attributes.
+//
+//	datoms-gen -pkg model -o model/schema_gen.go schema.edn
+//
+// The schema is read from EDN transaction data, the files are transacted in
+// order into a new in-memory database. Only EDN can be read, there's no
+// durable storage that the schema of an existing database could be loaded
+// from.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/storage/mem"
+)
+
+func main() {
+	var (
+		pkg = flag.String("pkg", "schema", "the package name of the Go source")
+		out = flag.String("o", "", "the Go source file, standard output if empty")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: datoms-gen [flags] schema.edn...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(*pkg, *out, flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(pkg, out string, files []string) error {
+	db, err := load(files)
+	if err != nil {
+		return err
+	}
+	src, err := generate(pkg, db.Schema())
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return os.WriteFile(out, src, 0o666)
+}
+
+// load transacts the EDN files into a new database
+func load(files []string) (database.Interface, error) {
+	db := database.Interface(mem.New())
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		txData, err := database.ReadTxData(data)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", file, err)
+		}
+		tx, err := db.With(txData)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", file, err)
+		}
+		db = tx.DbAfter
+	}
+	return db, nil
+}
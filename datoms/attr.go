@@ -0,0 +1,172 @@
+package datoms
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/symbol"
+)
+
+// Value is the Go types of the value types, int64 is :db.type/long. Ref
+// attributes are made with DefRef.
+type Value interface {
+	bool | float64 | string | int64 | symbol.Keyword | time.Time
+}
+
+// Attr is an attribute with values of type T
+//
+//	var Name = datoms.DefAttr[string](":person/name")
+//
+//	Name.Add(e, "Fred")
+type Attr[T Value] struct {
+	Ident symbol.Keyword
+}
+
+func DefAttr[T Value](ident string) Attr[T] {
+	return Attr[T]{Ident: symbol.For(ident)}
+}
+
+func (a Attr[T]) Add(e Entid, v T) TxData {
+	return base.TxAdd{E: e, A: a.Ident, V: v}
+}
+
+func (a Attr[T]) Retract(e Entid, v T) TxData {
+	return base.TxRetract{E: e, A: a.Ident, V: v}
+}
+
+// Get is the value of the attribute of an entity, if it's cardinality many
+// it's any one of the values. e is resolved against the database, ok is false
+// if it cannot be resolved.
+func (a Attr[T]) Get(db database.Interface, e Entid) (v T, ok bool) {
+	a.datoms(db, e, func(d Datom) bool {
+		v, ok = d.V.(T)
+		return false
+	})
+	return
+}
+
+// All are the values of the attribute of an entity
+func (a Attr[T]) All(db database.Interface, e Entid) []T {
+	var vals []T
+	a.datoms(db, e, func(d Datom) bool {
+		if v, ok := d.V.(T); ok {
+			vals = append(vals, v)
+		}
+		return true
+	})
+	return vals
+}
+
+func (a Attr[T]) datoms(db database.Interface, e Entid, yield func(d Datom) bool) {
+	datoms(db, a.Ident, e, yield)
+}
+
+func datoms(db database.Interface, ident symbol.Keyword, e Entid, yield func(d Datom) bool) {
+	attr, ok := db.Schema().AttrKeyword(ident)
+	if !ok {
+		return
+	}
+	id, err := database.ResolveEntid(db, e)
+	if err != nil {
+		return
+	}
+	db.Datoms(base.EAVT, id, attr.Id)(yield)
+}
+
+// Value is the value of a datom of the attribute, ok is false if the value
+// isn't a T
+func (a Attr[T]) Value(d Datom) (v T, ok bool) {
+	v, ok = d.V.(T)
+	return
+}
+
+// Check returns an error unless the attribute is installed with a value type
+// that T is the Go type of
+func (a Attr[T]) Check(db database.Interface) error {
+	var want symbol.Keyword
+	switch any(*new(T)).(type) {
+	case bool:
+		want = schema.DbTypeBoolean
+	case float64:
+		want = schema.DbTypeDouble
+	case string:
+		want = schema.DbTypeString
+	case int64:
+		want = schema.DbTypeLong
+	case symbol.Keyword:
+		want = schema.DbTypeKeyword
+	case time.Time:
+		want = schema.DbTypeInstant
+	}
+	return check(db, a.Ident, want)
+}
+
+func check(db database.Interface, ident, want symbol.Keyword) error {
+	s := db.Schema()
+	attr, ok := s.AttrKeyword(ident)
+	if !ok {
+		return fmt.Errorf("datoms: %v is not installed", ident)
+	}
+	if valueType, _ := s.Ident(attr.ValueType); valueType != want {
+		return fmt.Errorf("datoms: %v is %v, not %v", ident, valueType, want)
+	}
+	return nil
+}
+
+// Ref is a ref attribute, the values are entities
+//
+//	var Friends = datoms.DefRef(":person/friends")
+//
+//	Friends.Add(fred, ethel)
+type Ref struct {
+	Ident symbol.Keyword
+}
+
+func DefRef(ident string) Ref {
+	return Ref{Ident: symbol.For(ident)}
+}
+
+// Add refers to v from e, v is resolved by the transactor like e, it can be
+// a temp ID of the same transaction
+func (a Ref) Add(e Entid, v Entid) TxData {
+	return base.TxAdd{E: e, A: a.Ident, V: v}
+}
+
+func (a Ref) Retract(e Entid, v Entid) TxData {
+	return base.TxRetract{E: e, A: a.Ident, V: v}
+}
+
+// Get is the entity ID that an entity refers to, if it's cardinality many
+// it's any one of them. e is resolved against the database, ok is false if
+// it cannot be resolved.
+func (a Ref) Get(db database.Interface, e Entid) (id int64, ok bool) {
+	datoms(db, a.Ident, e, func(d Datom) bool {
+		id, ok = d.V.(int64)
+		return false
+	})
+	return
+}
+
+// All are the entity IDs that an entity refers to
+func (a Ref) All(db database.Interface, e Entid) []int64 {
+	var ids []int64
+	datoms(db, a.Ident, e, func(d Datom) bool {
+		ids = append(ids, d.V.(int64))
+		return true
+	})
+	return ids
+}
+
+// Value is the entity ID of a datom of the attribute
+func (a Ref) Value(d Datom) (id int64, ok bool) {
+	id, ok = d.V.(int64)
+	return
+}
+
+// Check returns an error unless the attribute is installed as :db.type/ref
+func (a Ref) Check(db database.Interface) error {
+	return check(db, a.Ident, schema.DbTypeRef)
+}
@@ -0,0 +1,106 @@
+package datoms_test
+
+import (
+	"testing"
+
+	"github.com/leidegre/datoms/datoms"
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/storage/mem"
+	"github.com/leidegre/datoms/symbol"
+	"github.com/leidegre/datoms/testutil"
+)
+
+var (
+	Name    = datoms.DefAttr[string](":person/name")
+	Age     = datoms.DefAttr[int64](":person/age")
+	Friends = datoms.DefRef(":person/friends")
+)
+
+func TestAttr(t *testing.T) {
+	txData, err := database.ReadTxData([]byte(`
+[{:db/id "name" :db/ident :person/name :db/valueType :db.type/string :db/cardinality :db.cardinality/one}
+ {:db/id "age" :db/ident :person/age :db/valueType :db.type/long :db/cardinality :db.cardinality/one}
+ {:db/id "friends" :db/ident :person/friends :db/valueType :db.type/ref :db/cardinality :db.cardinality/many}
+ {:db/id :db.part/db :db.install/attribute ["name" "age" "friends"]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := mem.New().With(txData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, err := range []error{Name.Check(tx.DbAfter), Age.Check(tx.DbAfter), Friends.Check(tx.DbAfter)} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := datoms.DefAttr[bool](":person/name").Check(tx.DbAfter); err == nil {
+		t.Error("expected an error for a string attribute as a bool")
+	}
+	if err := datoms.DefAttr[int64](":person/friends").Check(tx.DbAfter); err == nil {
+		t.Error("expected an error for a ref attribute as a long")
+	}
+	if err := datoms.DefRef(":person/age").Check(tx.DbAfter); err == nil {
+		t.Error("expected an error for a long attribute as a ref")
+	}
+	if err := datoms.DefAttr[bool](":person/unknown").Check(tx.DbAfter); err == nil {
+		t.Error("expected an error for an unknown attribute")
+	}
+
+	fred, ethel := base.NamedTempId("fred"), base.NamedTempId("ethel")
+	tx, err = tx.DbAfter.With([]base.TxData{
+		Name.Add(fred, "Fred"),
+		Age.Add(fred, 42),
+		Name.Add(ethel, "Ethel"),
+		Friends.Add(fred, ethel),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := tx.DbAfter
+	fredId, ethelId := tx.TempNames["fred"], tx.TempNames["ethel"]
+
+	testutil.AreEqualSlice(t, []int64{ethelId}, Friends.All(db, base.Entity{Id: fredId}))
+
+	tx, err = db.With([]base.TxData{
+		Friends.Retract(base.Entity{Id: fredId}, base.Entity{Id: ethelId}),
+		Friends.Add(base.Entity{Id: ethelId}, base.Entity{Id: fredId}),
+		Age.Retract(base.Entity{Id: fredId}, 42),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db = tx.DbAfter
+
+	name, ok := Name.Get(db, base.Entity{Id: fredId})
+	testutil.AreEqual(t, true, ok)
+	testutil.AreEqual(t, "Fred", name)
+
+	_, ok = Age.Get(db, base.Entity{Id: fredId})
+	testutil.AreEqual(t, false, ok)
+
+	_, ok = Friends.Get(db, base.Entity{Id: fredId})
+	testutil.AreEqual(t, false, ok)
+	friend, ok := Friends.Get(db, base.Entity{Id: ethelId})
+	testutil.AreEqual(t, true, ok)
+	testutil.AreEqual(t, fredId, friend)
+
+	// idents resolve against the schema
+	ident, ok := datoms.DefAttr[symbol.Keyword](":db/ident").Get(db, base.Entity{Ident: symbol.For(":person/name")})
+	testutil.AreEqual(t, true, ok)
+	testutil.AreEqual(t, symbol.For(":person/name"), ident)
+
+	_, ok = Name.Get(db, fred)
+	testutil.AreEqual(t, false, ok)
+
+	ageAttr, _ := db.Schema().AttrKeyword(Age.Ident)
+	for _, d := range tx.TxData {
+		if d.E == fredId && d.A == ageAttr.Id && d.Retraction() {
+			age, ok := Age.Value(d)
+			testutil.AreEqual(t, true, ok)
+			testutil.AreEqual(t, int64(42), age)
+		}
+	}
+}
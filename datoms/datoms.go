@@ -21,4 +21,6 @@ type (
 	TxData = base.TxData
 
 	TempId = base.TempId
+
+	Datom = base.Datom
 )
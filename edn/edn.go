@@ -0,0 +1,72 @@
+// Package edn reads and prints extensible data notation, https://github.com/edn-format/edn
+//
+// EDN values map onto Go values like this
+//
+//	nil, true, false   nil, bool
+//	42, 42N            int64, *big.Int
+//	3.14, 3.14M        float64, *big.Float
+//	"foo"              string
+//	\c                 Char
+//	:foo/bar           symbol.Keyword
+//	foo/bar            Symbol
+//	(a b c)            List
+//	[a b c]            []any
+//	{:a 1}             map[any]any
+//	#{a b c}           Set
+//	#inst "..."        time.Time
+//	#uuid "..."        UUID
+//	#foo/bar x         Tagged
+package edn
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Symbol is an EDN symbol like foo or foo/bar
+type Symbol string
+
+// Char is an EDN character like \a or \newline
+type Char rune
+
+// List is an EDN list like (a b c)
+type List []any
+
+// Set is an EDN set like #{a b c}, the elements are distinct
+type Set []any
+
+// Tagged is a tagged element that has no reader like #myapp/Person {:first "Fred"}
+type Tagged struct {
+	Tag   Symbol
+	Value any
+}
+
+// UUID is a universally unique identifier like #uuid "f81d4fae-7dec-11d0-a765-00a0c91e6bf6"
+type UUID [16]byte
+
+func (u UUID) String() string {
+	var b [36]byte
+	hex.Encode(b[0:8], u[0:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], u[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], u[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], u[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], u[10:])
+	return string(b[:])
+}
+
+// ParseUUID parses the canonical form of a UUID
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, fmt.Errorf("datoms: invalid uuid %q", s)
+	}
+	if _, err := hex.Decode(u[:], []byte(strings.ReplaceAll(s, "-", ""))); err != nil {
+		return u, fmt.Errorf("datoms: invalid uuid %q", s)
+	}
+	return u, nil
+}
@@ -0,0 +1,148 @@
+package edn_test
+
+import (
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/leidegre/datoms/edn"
+	"github.com/leidegre/datoms/symbol"
+	"github.com/leidegre/datoms/testutil"
+)
+
+func read(t *testing.T, s string) any {
+	v, err := edn.ReadString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func str(t *testing.T, v any) string {
+	s, err := edn.Print(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRead(t *testing.T) {
+	testutil.AreEqual(t, nil, read(t, "nil"))
+	testutil.AreEqual(t, true, read(t, "true"))
+	testutil.AreEqual(t, int64(-42), read(t, "-42").(int64))
+	testutil.AreEqual(t, 3.5, read(t, "3.5").(float64))
+	testutil.AreEqual(t, 1e10, read(t, "1e10").(float64))
+	testutil.AreEqual(t, "12345678901234567890", read(t, "12345678901234567890N").(*big.Int).String())
+	testutil.AreEqual(t, "foo\n\"bar\"é", read(t, `"foo\n\"bar\"é"`).(string))
+	testutil.AreEqual(t, edn.Char('a'), read(t, `\a`).(edn.Char))
+	testutil.AreEqual(t, edn.Char('\n'), read(t, `\newline`).(edn.Char))
+	testutil.AreEqual(t, symbol.For(":db/ident"), read(t, ":db/ident").(symbol.Keyword))
+	testutil.AreEqual(t, symbol.For(":db.type/string"), read(t, ":db.type/string").(symbol.Keyword))
+	testutil.AreEqual(t, edn.Symbol("?e"), read(t, "?e").(edn.Symbol))
+	testutil.AreEqual(t, edn.Symbol("clojure.core/+"), read(t, "clojure.core/+").(edn.Symbol))
+	testutil.AreEqual(t, edn.Symbol("..."), read(t, "...").(edn.Symbol))
+	testutil.AreEqual(t, true, math.IsInf(read(t, "##-Inf").(float64), -1))
+
+	inst := read(t, `#inst "1985-04-12T23:20:50.52Z"`).(time.Time)
+	testutil.AreEqual(t, true, inst.Equal(time.Date(1985, 4, 12, 23, 20, 50, 520000000, time.UTC)))
+
+	uuid := read(t, `#uuid "f81d4fae-7dec-11d0-a765-00a0c91e6bf6"`).(edn.UUID)
+	testutil.AreEqual(t, "f81d4fae-7dec-11d0-a765-00a0c91e6bf6", uuid.String())
+
+	tagged := read(t, `#myapp/Person {:first "Fred"}`).(edn.Tagged)
+	testutil.AreEqual(t, edn.Symbol("myapp/Person"), tagged.Tag)
+	testutil.AreEqual(t, "Fred", tagged.Value.(map[any]any)[symbol.For(":first")].(string))
+}
+
+func TestReadCollections(t *testing.T) {
+	v := read(t, `
+		; a comment
+		{:find [?e ?name]
+		 :where [[?e :person/name ?name]
+		         [(> ?age 21)]]
+		 :ids #{1 2 3}, #_ [:discarded 42]
+		 :empty []}`)
+
+	m := v.(map[any]any)
+	testutil.AreEqual(t, 4, len(m))
+
+	find := m[symbol.For(":find")].([]any)
+	testutil.AreEqual(t, edn.Symbol("?name"), find[1].(edn.Symbol))
+
+	where := m[symbol.For(":where")].([]any)
+	pred := where[1].([]any)[0].(edn.List)
+	testutil.AreEqual(t, edn.Symbol(">"), pred[0].(edn.Symbol))
+	testutil.AreEqual(t, int64(21), pred[2].(int64))
+
+	ids := m[symbol.For(":ids")].(edn.Set)
+	testutil.AreEqual(t, 3, len(ids))
+
+	testutil.AreEqual(t, 0, len(m[symbol.For(":empty")].([]any)))
+
+	all, err := edn.ReadAll([]byte("1 :a \"b\" ; trailing comment"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.AreEqual(t, 3, len(all))
+}
+
+func TestReadErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"1 2",
+		"[1 2",
+		"(1 2]",
+		"{:a}",
+		"{:a 1 :a 2}",
+		"#{1 1}",
+		"{[1] 2}",
+		`"foo`,
+		`"\q"`,
+		"::foo",
+		":",
+		"1/2",
+		`\foo`,
+		`#inst "yesterday"`,
+		`#uuid "nope"`,
+		"##Nope",
+	} {
+		if _, err := edn.ReadString(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+	testutil.AreEqual(t, "nil", str(t, nil))
+	testutil.AreEqual(t, "42", str(t, 42))
+	testutil.AreEqual(t, "42.0", str(t, 42.0))
+	testutil.AreEqual(t, "42N", str(t, big.NewInt(42)))
+	testutil.AreEqual(t, `"a\"b\n"`, str(t, "a\"b\n"))
+	testutil.AreEqual(t, `\space`, str(t, edn.Char(' ')))
+	testutil.AreEqual(t, ":person/name", str(t, symbol.For(":person/name")))
+	testutil.AreEqual(t, "[1 2 3]", str(t, []int64{1, 2, 3}))
+	testutil.AreEqual(t, "(f ?x)", str(t, edn.List{edn.Symbol("f"), edn.Symbol("?x")}))
+	testutil.AreEqual(t, "#{:a}", str(t, edn.Set{symbol.For(":a")}))
+	testutil.AreEqual(t, "{:a 1, :b [2]}", str(t, map[symbol.Keyword]any{symbol.For(":b"): []any{2}, symbol.For(":a"): 1}))
+	testutil.AreEqual(t, `#inst "2020-01-02T03:04:05Z"`, str(t, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)))
+
+	if _, err := edn.Print(struct{}{}); err == nil {
+		t.Error("expected an error for a struct")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, s := range []string{
+		`[:db/add "tempid" :person/name "Fred"]`,
+		`{:db/ident :person/name, :db/valueType :db.type/string}`,
+		`#{1 2.5 "three" \4 :five six}`,
+		`(rule ?a ?b)`,
+		`#uuid "f81d4fae-7dec-11d0-a765-00a0c91e6bf6"`,
+		`#inst "1985-04-12T23:20:50.52Z"`,
+		`#myapp/Person {:first "Fred"}`,
+		`[nil true false -1 1.5e-07 12345678901234567890N 1.5M ##Inf]`,
+	} {
+		testutil.AreEqual(t, s, str(t, read(t, s)))
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/leidegre/datoms/edn
+
+go 1.21
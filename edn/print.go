@@ -0,0 +1,194 @@
+package edn
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/leidegre/datoms/symbol"
+)
+
+// Print prints a value as EDN, map entries are sorted so that equal values
+// print the same
+func Print(v any) (string, error) {
+	b, err := Append(nil, v)
+	return string(b), err
+}
+
+// Append appends the EDN of a value to b. Besides the types that Read
+// produces any Go integer, float, slice, array or map can be printed.
+func Append(b []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, "nil"...), nil
+	case bool:
+		return strconv.AppendBool(b, v), nil
+	case int64:
+		return strconv.AppendInt(b, v, 10), nil
+	case int:
+		return strconv.AppendInt(b, int64(v), 10), nil
+	case float64:
+		return appendFloat(b, v), nil
+	case *big.Int:
+		return append(append(b, v.String()...), 'N'), nil
+	case *big.Float:
+		return append(append(b, v.Text('g', -1)...), 'M'), nil
+	case string:
+		return appendString(b, v), nil
+	case Char:
+		return appendChar(b, v), nil
+	case symbol.Keyword:
+		return append(b, v.String()...), nil
+	case Symbol:
+		return append(b, v...), nil
+	case List:
+		return appendElems(append(b, '('), v, ')')
+	case Set:
+		return appendElems(append(b, '#', '{'), v, '}')
+	case []any:
+		return appendElems(append(b, '['), v, ']')
+	case time.Time:
+		b = append(b, `#inst "`...)
+		return append(v.AppendFormat(b, time.RFC3339Nano), '"'), nil
+	case UUID:
+		return append(append(append(b, `#uuid "`...), v.String()...), '"'), nil
+	case Tagged:
+		b = append(append(append(b, '#'), v.Tag...), ' ')
+		return Append(b, v.Value)
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.AppendInt(b, rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.AppendUint(b, rv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return appendFloat(b, rv.Float()), nil
+	case reflect.String:
+		return appendString(b, rv.String()), nil
+	case reflect.Bool:
+		return strconv.AppendBool(b, rv.Bool()), nil
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return append(b, "nil"...), nil
+		}
+		elems := make([]any, rv.Len())
+		for i := range elems {
+			elems[i] = rv.Index(i).Interface()
+		}
+		return appendElems(append(b, '['), elems, ']')
+	case reflect.Map:
+		if rv.IsNil() {
+			return append(b, "nil"...), nil
+		}
+		return appendMap(b, rv)
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return append(b, "nil"...), nil
+		}
+		return Append(b, rv.Elem().Interface())
+	default:
+		return nil, fmt.Errorf("datoms: edn cannot print %T", v)
+	}
+}
+
+func appendFloat(b []byte, f float64) []byte {
+	switch {
+	case math.IsInf(f, 1):
+		return append(b, "##Inf"...)
+	case math.IsInf(f, -1):
+		return append(b, "##-Inf"...)
+	case math.IsNaN(f):
+		return append(b, "##NaN"...)
+	}
+	start := len(b)
+	b = strconv.AppendFloat(b, f, 'g', -1, 64)
+	if !strings.ContainsAny(string(b[start:]), ".e") {
+		b = append(b, ".0"...)
+	}
+	return b
+}
+
+func appendString(b []byte, s string) []byte {
+	b = append(b, '"')
+	for _, c := range s {
+		switch c {
+		case '"':
+			b = append(b, `\"`...)
+		case '\\':
+			b = append(b, `\\`...)
+		case '\n':
+			b = append(b, `\n`...)
+		case '\r':
+			b = append(b, `\r`...)
+		case '\t':
+			b = append(b, `\t`...)
+		default:
+			if c < ' ' {
+				b = append(b, fmt.Sprintf(`\u%04x`, c)...)
+			} else {
+				b = utf8.AppendRune(b, c)
+			}
+		}
+	}
+	return append(b, '"')
+}
+
+func appendChar(b []byte, c Char) []byte {
+	for name, ch := range charNames {
+		if ch == c {
+			return append(append(b, '\\'), name...)
+		}
+	}
+	if c < ' ' {
+		return append(b, fmt.Sprintf(`\u%04x`, c)...)
+	}
+	return utf8.AppendRune(append(b, '\\'), rune(c))
+}
+
+func appendElems(b []byte, elems []any, end byte) ([]byte, error) {
+	for i, v := range elems {
+		if 0 < i {
+			b = append(b, ' ')
+		}
+		var err error
+		if b, err = Append(b, v); err != nil {
+			return nil, err
+		}
+	}
+	return append(b, end), nil
+}
+
+func appendMap(b []byte, rv reflect.Value) ([]byte, error) {
+	type entry struct{ k, v []byte }
+	var entries []entry
+	iter := rv.MapRange()
+	for iter.Next() {
+		k, err := Append(nil, iter.Key().Interface())
+		if err != nil {
+			return nil, err
+		}
+		v, err := Append(nil, iter.Value().Interface())
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry{k, v})
+	}
+	slices.SortFunc(entries, func(x, y entry) int { return strings.Compare(string(x.k), string(y.k)) })
+
+	b = append(b, '{')
+	for i, e := range entries {
+		if 0 < i {
+			b = append(b, ", "...)
+		}
+		b = append(append(append(b, e.k...), ' '), e.v...)
+	}
+	return append(b, '}'), nil
+}
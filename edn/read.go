@@ -0,0 +1,485 @@
+package edn
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/leidegre/datoms/symbol"
+)
+
+// Read reads a single EDN value
+func Read(data []byte) (any, error) {
+	r := reader{data: data, line: 1}
+	v, ok, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, r.errorf("expected a value")
+	}
+	if _, ok, err := r.read(); err != nil || ok {
+		if err != nil {
+			return nil, err
+		}
+		return nil, r.errorf("expected a single value")
+	}
+	return v, nil
+}
+
+// ReadAll reads every EDN value
+func ReadAll(data []byte) ([]any, error) {
+	r := reader{data: data, line: 1}
+	var vals []any
+	for {
+		v, ok, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return vals, nil
+		}
+		vals = append(vals, v)
+	}
+}
+
+// ReadString reads a single EDN value
+func ReadString(s string) (any, error) {
+	return Read([]byte(s))
+}
+
+type reader struct {
+	data []byte
+	pos  int
+	line int
+}
+
+func (r *reader) errorf(format string, args ...any) error {
+	return fmt.Errorf("datoms: edn line %v: %v", r.line, fmt.Sprintf(format, args...))
+}
+
+func isWhitespace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ','
+}
+
+func isDelimiter(c byte) bool {
+	return isWhitespace(c) || strings.IndexByte("()[]{}\";", c) != -1
+}
+
+// skip skips whitespace and comments
+func (r *reader) skip() {
+	for r.pos < len(r.data) {
+		switch c := r.data[r.pos]; {
+		case c == '\n':
+			r.line++
+			r.pos++
+		case isWhitespace(c):
+			r.pos++
+		case c == ';':
+			for r.pos < len(r.data) && r.data[r.pos] != '\n' {
+				r.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// read reads the next value, it reports false at the end of the data
+func (r *reader) read() (any, bool, error) {
+	for {
+		r.skip()
+		if len(r.data) <= r.pos {
+			return nil, false, nil
+		}
+		if r.data[r.pos] == '#' && r.pos+1 < len(r.data) && r.data[r.pos+1] == '_' {
+			r.pos += 2
+			if _, err := r.value(); err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		v, err := r.value()
+		return v, err == nil, err
+	}
+}
+
+// value reads a value that must be there
+func (r *reader) value() (any, error) {
+	r.skip()
+	if len(r.data) <= r.pos {
+		return nil, r.errorf("unexpected end of data")
+	}
+	switch c := r.data[r.pos]; c {
+	case '(':
+		r.pos++
+		vals, err := r.elems(')')
+		return List(vals), err
+	case '[':
+		r.pos++
+		vals, err := r.elems(']')
+		if vals == nil && err == nil {
+			vals = []any{}
+		}
+		return vals, err
+	case '{':
+		r.pos++
+		return r.readMap()
+	case ')', ']', '}':
+		return nil, r.errorf("unexpected %c", c)
+	case '"':
+		return r.readString()
+	case '\\':
+		return r.readChar()
+	case '#':
+		return r.dispatch()
+	default:
+		return r.token()
+	}
+}
+
+// elems reads values until the closing delimiter
+func (r *reader) elems(end byte) ([]any, error) {
+	var vals []any
+	for {
+		r.skip()
+		if len(r.data) <= r.pos {
+			return nil, r.errorf("expected %c", end)
+		}
+		if r.data[r.pos] == end {
+			r.pos++
+			return vals, nil
+		}
+		if r.data[r.pos] == '#' && r.pos+1 < len(r.data) && r.data[r.pos+1] == '_' {
+			r.pos += 2
+			if _, err := r.value(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		v, err := r.value()
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, v)
+	}
+}
+
+func (r *reader) readMap() (any, error) {
+	vals, err := r.elems('}')
+	if err != nil {
+		return nil, err
+	}
+	if len(vals)%2 != 0 {
+		return nil, r.errorf("map has a key without a value")
+	}
+	m := make(map[any]any, len(vals)/2)
+	for i := 0; i < len(vals); i += 2 {
+		k := vals[i]
+		if k != nil && !reflect.ValueOf(k).Comparable() {
+			return nil, r.errorf("map key %v cannot be used as a Go map key", str(k))
+		}
+		if _, ok := m[k]; ok {
+			return nil, r.errorf("duplicate map key %v", str(k))
+		}
+		m[k] = vals[i+1]
+	}
+	return m, nil
+}
+
+func (r *reader) readSet() (any, error) {
+	vals, err := r.elems('}')
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(vals))
+	for _, v := range vals {
+		k := str(v)
+		if seen[k] {
+			return nil, r.errorf("duplicate set element %v", k)
+		}
+		seen[k] = true
+	}
+	if vals == nil {
+		vals = []any{}
+	}
+	return Set(vals), nil
+}
+
+func (r *reader) readString() (any, error) {
+	r.pos++ // "
+	var sb strings.Builder
+	for {
+		if len(r.data) <= r.pos {
+			return nil, r.errorf("unterminated string")
+		}
+		c := r.data[r.pos]
+		r.pos++
+		switch c {
+		case '"':
+			return sb.String(), nil
+		case '\n':
+			r.line++
+			sb.WriteByte(c)
+		case '\\':
+			if len(r.data) <= r.pos {
+				return nil, r.errorf("unterminated string")
+			}
+			e := r.data[r.pos]
+			r.pos++
+			switch e {
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case 'n':
+				sb.WriteByte('\n')
+			case 'b':
+				sb.WriteByte('\b')
+			case 'f':
+				sb.WriteByte('\f')
+			case '\\', '"':
+				sb.WriteByte(e)
+			case 'u':
+				if len(r.data) < r.pos+4 {
+					return nil, r.errorf("invalid unicode escape")
+				}
+				n, err := strconv.ParseUint(string(r.data[r.pos:r.pos+4]), 16, 16)
+				if err != nil {
+					return nil, r.errorf("invalid unicode escape")
+				}
+				r.pos += 4
+				sb.WriteRune(rune(n))
+			default:
+				return nil, r.errorf("invalid escape \\%c", e)
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+}
+
+var charNames = map[string]Char{
+	"newline": '\n',
+	"return":  '\r',
+	"space":   ' ',
+	"tab":     '\t',
+}
+
+func (r *reader) readChar() (any, error) {
+	r.pos++ // \
+	if len(r.data) <= r.pos {
+		return nil, r.errorf("unexpected end of data")
+	}
+	c, n := utf8.DecodeRune(r.data[r.pos:])
+	start := r.pos
+	r.pos += n
+	for r.pos < len(r.data) && !isDelimiter(r.data[r.pos]) {
+		r.pos++
+	}
+	s := string(r.data[start:r.pos])
+	if len(s) == n {
+		return Char(c), nil
+	}
+	if ch, ok := charNames[s]; ok {
+		return ch, nil
+	}
+	if s[0] == 'u' && len(s) == 5 {
+		if n, err := strconv.ParseUint(s[1:], 16, 16); err == nil {
+			return Char(n), nil
+		}
+	}
+	return nil, r.errorf("invalid character \\%v", s)
+}
+
+func (r *reader) dispatch() (any, error) {
+	r.pos++ // #
+	if len(r.data) <= r.pos {
+		return nil, r.errorf("unexpected end of data")
+	}
+	switch r.data[r.pos] {
+	case '{':
+		r.pos++
+		return r.readSet()
+	case '#':
+		r.pos++
+		tok := r.word()
+		switch tok {
+		case "Inf":
+			return math.Inf(1), nil
+		case "-Inf":
+			return math.Inf(-1), nil
+		case "NaN":
+			return math.NaN(), nil
+		}
+		return nil, r.errorf("invalid symbolic value ##%v", tok)
+	}
+
+	tag := r.word()
+	if tag == "" || !isSymbol(tag) {
+		return nil, r.errorf("invalid tag #%v", tag)
+	}
+	v, err := r.value()
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case "inst":
+		s, ok := v.(string)
+		if !ok {
+			return nil, r.errorf("#inst expects a string")
+		}
+		t, err := parseInst(s)
+		if err != nil {
+			return nil, r.errorf("%v", err)
+		}
+		return t, nil
+	case "uuid":
+		s, ok := v.(string)
+		if !ok {
+			return nil, r.errorf("#uuid expects a string")
+		}
+		u, err := ParseUUID(s)
+		if err != nil {
+			return nil, r.errorf("invalid #uuid %q", s)
+		}
+		return u, nil
+	default:
+		return Tagged{Symbol(tag), v}, nil
+	}
+}
+
+var instLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+func parseInst(s string) (time.Time, error) {
+	for _, layout := range instLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid #inst %q", s)
+}
+
+// word reads up to the next delimiter
+func (r *reader) word() string {
+	start := r.pos
+	for r.pos < len(r.data) && !isDelimiter(r.data[r.pos]) {
+		r.pos++
+	}
+	return string(r.data[start:r.pos])
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+// token reads a number, keyword, symbol, nil, true or false
+func (r *reader) token() (any, error) {
+	tok := r.word()
+	if tok == "" {
+		return nil, r.errorf("unexpected %q", r.data[r.pos])
+	}
+	switch {
+	case isDigit(tok[0]) || (1 < len(tok) && (tok[0] == '+' || tok[0] == '-') && isDigit(tok[1])):
+		return r.number(tok)
+	case tok[0] == ':':
+		if len(tok) == 1 || tok[1] == ':' || !isSymbol(tok[1:]) {
+			return nil, r.errorf("invalid keyword %v", tok)
+		}
+		return symbol.For(tok), nil
+	case tok == "nil":
+		return nil, nil
+	case tok == "true":
+		return true, nil
+	case tok == "false":
+		return false, nil
+	case isSymbol(tok):
+		return Symbol(tok), nil
+	default:
+		return nil, r.errorf("invalid symbol %v", tok)
+	}
+}
+
+func (r *reader) number(tok string) (any, error) {
+	switch {
+	case strings.HasSuffix(tok, "N"):
+		n, ok := new(big.Int).SetString(strings.TrimPrefix(tok[:len(tok)-1], "+"), 10)
+		if !ok {
+			return nil, r.errorf("invalid number %v", tok)
+		}
+		return n, nil
+	case strings.HasSuffix(tok, "M"):
+		f, ok := new(big.Float).SetString(tok[:len(tok)-1])
+		if !ok {
+			return nil, r.errorf("invalid number %v", tok)
+		}
+		return f, nil
+	case strings.ContainsAny(tok, ".eE"):
+		f, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, r.errorf("invalid number %v", tok)
+		}
+		return f, nil
+	default:
+		n, err := strconv.ParseInt(tok, 10, 64)
+		if err != nil {
+			return nil, r.errorf("invalid number %v", tok)
+		}
+		return n, nil
+	}
+}
+
+// isSymbol reports whether s is a valid symbol name, with an optional prefix
+func isSymbol(s string) bool {
+	if s == "/" {
+		return true
+	}
+	prefix, name, found := strings.Cut(s, "/")
+	if found {
+		return isSymbolPart(prefix) && isSymbolPart(name)
+	}
+	return isSymbolPart(s)
+}
+
+func isSymbolPart(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', c == '_':
+		case isDigit(c):
+			if i == 0 || (i == 1 && (s[0] == '+' || s[0] == '-' || s[0] == '.')) {
+				return false
+			}
+		case strings.IndexByte(".*+!-?$%&=<>'", c) != -1:
+		case c == ':' || c == '#':
+			if i == 0 {
+				return false
+			}
+		case utf8.RuneStart(c) && 0x80 <= c:
+		default:
+			if c < 0x80 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// str prints a value that was read
+func str(v any) string {
+	s, _ := Print(v)
+	return s
+}
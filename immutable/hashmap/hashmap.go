@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/leidegre/datoms/cow"
+	"github.com/leidegre/datoms/iter"
 )
 
 // We have three types of internal nodes to contend with these implement this interface.
@@ -15,6 +16,7 @@ type node[K comparable, V comparable] interface {
 	get(k K, hash, shift uint64) (v V, ok bool)
 	set(k K, hash, shift uint64, v V, inserted *uint32) node[K, V]
 	delete(k K, hash, shift uint64) node[K, V]
+	all(yield func(k K, v V) bool) bool
 }
 
 const (
@@ -78,6 +80,16 @@ func (m Persistent[K, V]) Delete(k K, h uint64) Persistent[K, V] {
 	return m
 }
 
+// All iterates over every key-value pair in the map. The order is unspecified.
+func (m Persistent[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(k K, v V) bool) {
+		if m.root == nil {
+			return
+		}
+		m.root.all(yield)
+	}
+}
+
 func debugString[K comparable, V comparable](lvl int, root node[K, V]) (s string) {
 	switch n := root.(type) {
 	case *bitmapNode[K, V]:
@@ -195,6 +207,15 @@ func (n *bitmapNode[K, V]) delete(k K, hash uint64, shift uint64) node[K, V] {
 	}
 }
 
+func (n *bitmapNode[K, V]) all(yield func(k K, v V) bool) bool {
+	for _, n := range n.data {
+		if !n.all(yield) {
+			return false
+		}
+	}
+	return true
+}
+
 type valueNode[K comparable, V comparable] struct {
 	k K
 	h uint64
@@ -231,6 +252,10 @@ func (n *valueNode[K, V]) delete(k K, hash uint64, shift uint64) node[K, V] {
 	return n
 }
 
+func (n *valueNode[K, V]) all(yield func(k K, v V) bool) bool {
+	return yield(n.k, n.v)
+}
+
 type bucketNode[K comparable, V comparable] struct {
 	collisions []*valueNode[K, V]
 }
@@ -273,6 +298,15 @@ func (n *bucketNode[K, V]) delete(k K, hash uint64, shift uint64) node[K, V] {
 	return n
 }
 
+func (n *bucketNode[K, V]) all(yield func(k K, v V) bool) bool {
+	for _, collision := range n.collisions {
+		if !yield(collision.k, collision.v) {
+			return false
+		}
+	}
+	return true
+}
+
 type Transient[K comparable, V comparable] struct {
 	Persistent[K, V]
 }
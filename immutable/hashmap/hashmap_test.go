@@ -340,3 +340,33 @@ func TestTransient(t *testing.T) {
 		}
 	}
 }
+
+func TestAll(t *testing.T) {
+	var m hashmap.Persistent[int, int]
+
+	m = m.Set(1, 0, 1) // collision
+	m = m.Set(2, 0, 4)
+	for i := 3; i < 100; i++ {
+		m = m.Set(i, hash.Int(i), i*i)
+	}
+
+	var n, s int
+	m.All()(func(k, v int) bool {
+		if !(v == k*k) {
+			t.Fatalf("unexpected pair %v %v", k, v)
+		}
+		n, s = n+1, s+k
+		return true
+	})
+
+	testutil.AreEqual(t, 99, n)
+	testutil.AreEqual(t, 99*100/2, s)
+
+	n = 0
+	m.All()(func(k, v int) bool {
+		n++
+		return n < 10
+	})
+
+	testutil.AreEqual(t, 10, n)
+}
@@ -13,6 +13,21 @@ const (
 	VAET              // Value-Attribute-Entity (graph like)
 )
 
+func (index Index) String() string {
+	switch index {
+	case EAVT:
+		return "EAVT"
+	case AEVT:
+		return "AEVT"
+	case AVET:
+		return "AVET"
+	case VAET:
+		return "VAET"
+	default:
+		return "Index(?)"
+	}
+}
+
 type Entid interface {
 	Zero() bool
 
@@ -2,6 +2,7 @@ package base_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/leidegre/datoms/internal/base"
 	"github.com/leidegre/datoms/symbol"
@@ -87,3 +88,26 @@ func TestEntity(t *testing.T) {
 		testutil.AreEqual(t, 2, len(m))
 	})
 }
+
+func TestAppendValue(t *testing.T) {
+	key := func(v any) string {
+		b, ok := base.AppendValue(nil, v)
+		if !ok {
+			t.Fatalf("%T is a value type", v)
+		}
+		return string(b)
+	}
+
+	// strings are length prefixed so that they don't run into what's after
+	testutil.AreEqual(t, false, key("ab")+key("c") == key("a")+key("bc"))
+	testutil.AreEqual(t, false, key("a") == key(symbol.For("a")))
+	testutil.AreEqual(t, false, key(int64(1)) == key(1.0))
+
+	// the same point in time
+	now := time.Now()
+	testutil.AreEqual(t, key(now), key(now.UTC()))
+
+	if _, ok := base.AppendValue(nil, []byte("a")); ok {
+		t.Error("expected a byte slice not to be a value")
+	}
+}
@@ -21,3 +21,10 @@ var (
 func NewTempId(part symbol.Keyword) TempId {
 	return TempId{Part: Entity{Ident: part}, TempId: atomic.AddInt64(&tempId, 1)}
 }
+
+// NamedTempId is a temp ID like "fred" that resolves to the same entity
+// everywhere within a transaction. It's in the user partition.
+type NamedTempId string
+
+func (name NamedTempId) Zero() bool { return name == "" }
+func (NamedTempId) entid()          {}
@@ -25,6 +25,28 @@ func (TxRetract) txData() {}
 type TxMap struct {
 	Id     Entid
 	Entity EntityLike
+	Diff   bool // retract the values that the entity has but the struct doesn't
 }
 
 func (TxMap) txData() {}
+
+// TxEntity is an entity as a map from attributes to values, like
+// {:db/id "fred" :person/name "Fred"}. The optional :db/id is an entity ID,
+// an ident, a temp ID or a named temp ID. The values of cardinality many
+// attributes are slices. The values of ref attributes can be entities of
+// their own, which are transacted as well.
+type TxEntity map[symbol.Keyword]any
+
+func (TxEntity) txData() {}
+
+// TxExcise permanently removes the datoms of an entity, optionally limited
+// to some attributes. If the entity is an attribute all the datoms of that
+// attribute are removed. BeforeT, if set, limits the excision to datoms that
+// were transacted before T.
+type TxExcise struct {
+	E       Entid
+	Attrs   []symbol.Keyword
+	BeforeT int64
+}
+
+func (TxExcise) txData() {}
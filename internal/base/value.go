@@ -0,0 +1,44 @@
+package base
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/leidegre/datoms/symbol"
+)
+
+// AppendValue appends an encoding of a value that tells values apart, two
+// values have the same encoding if they are equal. Instants are equal if
+// they are the same point in time. ok is false unless v is the Go type of a
+// value type: bool, float64, string, int64 (long and ref), symbol.Keyword or
+// time.Time.
+func AppendValue(b []byte, v any) (_ []byte, ok bool) {
+	switch v := v.(type) {
+	case int64:
+		b = append(b, 'i')
+		return binary.LittleEndian.AppendUint64(b, uint64(v)), true
+	case string:
+		b = append(b, 's')
+		b = binary.AppendUvarint(b, uint64(len(v)))
+		return append(b, v...), true
+	case symbol.Keyword:
+		b = append(b, 'k')
+		b = binary.AppendUvarint(b, uint64(len(v.String())))
+		return append(b, v.String()...), true
+	case bool:
+		if v {
+			return append(b, 't'), true
+		}
+		return append(b, 'f'), true
+	case float64:
+		b = append(b, 'd')
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(v)), true
+	case time.Time:
+		b = append(b, '@')
+		b = binary.LittleEndian.AppendUint64(b, uint64(v.Unix()))
+		return binary.LittleEndian.AppendUint32(b, uint32(v.Nanosecond())), true
+	default:
+		return b, false
+	}
+}
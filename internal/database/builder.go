@@ -1,11 +1,15 @@
 package database
 
 import (
+	"fmt"
 	"reflect"
+	"slices"
+	"strings"
 
 	"github.com/leidegre/datoms/internal/base"
 	"github.com/leidegre/datoms/internal/pack"
 	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/internal/sort"
 	"github.com/leidegre/datoms/symbol"
 )
 
@@ -17,8 +21,13 @@ type txBuilder struct {
 	db           Interface
 	baseT, nextT int64
 	schema       schema.Interface
+	refType      int64
+	cardOne      int64
 	entities     map[int64]*entity
+	nextIds      map[int64]int64
 	tempIds      map[int64]int64
+	named        map[string]int64  // named temp IDs
+	expanded     map[uintptr]int64 // structs by pointer, for cycles
 	data         []base.Datom
 }
 
@@ -27,21 +36,69 @@ func (tx *txBuilder) init(db Interface) {
 
 	tx.db = db
 	tx.baseT = nextT
-	tx.nextT = nextT
+	tx.nextT = nextT + 1
 	tx.schema = db.Schema()
+	tx.refType, _ = tx.schema.Id(schema.DbTypeRef)
+	tx.cardOne, _ = tx.schema.Id(schema.DbCardinalityOne)
 	tx.entities = nil
+	tx.nextIds = make(map[int64]int64)
 	tx.tempIds = make(map[int64]int64)
+	tx.named = make(map[string]int64)
+	tx.expanded = make(map[uintptr]int64)
 	tx.data = nil
 }
 
+func (tx *txBuilder) resolvePartition(part base.Entity) (int64, error) {
+	if part.Id != 0 {
+		if ident, ok := tx.schema.Ident(part.Id); ok {
+			part.Ident = ident
+		}
+	}
+	// a partition that isn't in :db.part/db can't be packed into entity IDs
+	if partId, ok := tx.schema.Partition(part.Ident); ok && pack.IsPartition(partId) {
+		return partId, nil
+	}
+	return 0, base.ErrCannotResolvePartition
+}
+
+// newId allocates the next entity ID within partition
+func (tx *txBuilder) newId(part int64) int64 {
+	next, ok := tx.nextIds[part]
+	if !ok {
+		next = tx.db.NextId(part)
+	}
+	tx.nextIds[part] = next + 1
+	return pack.EntityId(part, next)
+}
+
 func (tx *txBuilder) resolveEntid(id base.Entid) (int64, error) {
 	switch id := id.(type) {
 	case base.TempId:
-		partId, ok := tx.schema.Id(id.Part.Ident)
-		if !ok {
-			return 0, base.ErrCannotResolvePartition
+		partId, err := tx.resolvePartition(id.Part)
+		if err != nil {
+			return 0, err
 		}
 		return pack.TempId(partId, id.TempId), nil
+	case base.NamedTempId:
+		if tempId, ok := tx.named[string(id)]; ok {
+			return tempId, nil
+		}
+		tempId, err := tx.resolveEntid(base.NewTempId(schema.DbPartUser))
+		if err != nil {
+			return 0, err
+		}
+		tx.named[string(id)] = tempId
+		return tempId, nil
+	case base.EntityLike:
+		entId, entIdent := base.EntityIdentities(id)
+		if entId != 0 {
+			return entId, nil
+		}
+		// this includes the old idents of renamed entities
+		if entId, ok := tx.schema.Id(entIdent); ok {
+			return entId, nil
+		}
+		return 0, base.ErrCannotResolve
 	default:
 		return 0, base.ErrCannotResolve
 	}
@@ -65,21 +122,127 @@ func (tx *txBuilder) emitEntidAttr(e base.Entid, a symbol.Keyword, v interface{}
 		return
 	}
 
-	tx.emit(entid, attr, v, op)
+	err = tx.emit(entid, attr, v, op)
 	return
 }
 
+// resolveRef resolves the value of a ref attribute to an entity ID
+func (tx *txBuilder) resolveRef(v interface{}) (int64, error) {
+	switch v := v.(type) {
+	case int64:
+		return v, nil
+	case symbol.Keyword:
+		if id, ok := tx.schema.Id(v); ok {
+			return id, nil
+		}
+		return 0, base.ErrCannotResolve
+	case string:
+		return tx.resolveEntid(base.NamedTempId(v))
+	case base.Entid:
+		return tx.resolveEntid(v)
+	default:
+		return 0, base.ErrCannotResolve
+	}
+}
+
 func (tx *txBuilder) emit(e int64, attr schema.Attr, v interface{}, op int64) (err error) {
 	// todo: canonicalize value
 	// todo: what if attr is identity
 
+	if attr.ValueType == tx.refType {
+		if v, err = tx.resolveRef(v); err != nil {
+			return
+		}
+	}
+
 	var d = base.NewDatom(e, attr.Id, v, tx.baseT, op)
 
 	tx.data = append(tx.data, d)
 	return
 }
 
-func (tx *txBuilder) txExpand(entid base.Entid, e base.EntityLike) (id int64, err error) {
+// txImplicit adds the retractions that are implied by assertions of
+// cardinality one attributes on existing entities and drops assertions of
+// values that are already present.
+func (tx *txBuilder) txImplicit() {
+	var retracted map[eav]bool
+	for _, d := range tx.data {
+		if d.Retraction() {
+			if retracted == nil {
+				retracted = make(map[eav]bool)
+			}
+			retracted[eavOf(d)] = true
+		}
+	}
+
+	var data []base.Datom
+	for _, d := range tx.data {
+		if 0 < d.E && d.Assertion() {
+			attr, _ := tx.schema.Attr(d.A)
+			redundant := false
+			tx.db.Datoms(base.EAVT, d.E, d.A)(func(curr base.Datom) bool {
+				if sort.CompareValue(curr.V, d.V) == 0 {
+					redundant = true
+				} else if attr.Cardinality == tx.cardOne && !retracted[eavOf(curr)] {
+					data = append(data, base.NewDatom(d.E, d.A, curr.V, tx.baseT, 0))
+				}
+				return true
+			})
+			if redundant {
+				continue
+			}
+		}
+		data = append(data, d)
+	}
+	tx.data = data
+}
+
+// checkNamed fails for the named temp IDs that are never the entity of a
+// datom, a name that is only used as a value would be a dangling ref
+func (tx *txBuilder) checkNamed() error {
+	entities := make(map[int64]bool)
+	for _, d := range tx.data {
+		entities[d.E] = true
+	}
+	var names []string
+	for name, tempId := range tx.named {
+		if !entities[tempId] {
+			names = append(names, name)
+		}
+	}
+	if names == nil {
+		return nil
+	}
+	slices.Sort(names)
+	return fmt.Errorf("datoms: temp ID %q is only used as a value", names[0])
+}
+
+func (tx *txBuilder) txExcise(item base.TxExcise) (err error) {
+	target, err := tx.resolveEntid(item.E)
+	if err != nil {
+		return
+	}
+	if target < 0 {
+		return base.ErrCannotResolve // cannot excise what does not exist yet
+	}
+
+	excision := base.NewTempId(schema.DbPartUser)
+
+	if err = tx.emitEntidAttr(excision, schema.DbExcise, target, 1); err != nil {
+		return
+	}
+	for _, a := range item.Attrs {
+		if err = tx.emitEntidAttr(excision, schema.DbExciseAttrs, a, 1); err != nil {
+			return
+		}
+	}
+	if item.BeforeT != 0 {
+		err = tx.emitEntidAttr(excision, schema.DbExciseBeforeT, item.BeforeT, 1)
+	}
+	return
+}
+
+func (tx *txBuilder) txExpand(entid base.Entid, e base.EntityLike, diff bool) (id int64, err error) {
 	if e == nil {
 		panic("datoms: a top-level transaction map cannot be nil") // or do we silently ignore this?
 	}
@@ -98,25 +261,253 @@ func (tx *txBuilder) txExpand(entid base.Entid, e base.EntityLike) (id int64, er
 	}
 
 	v := reflect.ValueOf(e)
+	if v.Kind() == reflect.Pointer {
+		tx.expanded[v.Pointer()] = id
+		v = v.Elem()
+	}
+
+	err = tx.expandFields(id, v, diff)
+	return
+}
+
+// expandFields emits the datoms of the fields of a struct
+func (tx *txBuilder) expandFields(id int64, v reflect.Value, diff bool) error {
 	t := contractType(v.Type())
 
 	for _, tf := range t.Fields {
-		vf := v.FieldByIndex(tf.Index)
-		switch tf.Kind {
-		case reflect.Pointer, reflect.Slice:
-			if vf.IsNil() {
-				continue
+		vals := fieldValues(tf.Type, v.FieldByIndex(tf.Index))
+		if len(vals) == 0 && !diff {
+			continue
+		}
+		attr, err := tx.resolveAttr(tf.Ident)
+		if err != nil {
+			return err
+		}
+		if attr.Cardinality == tx.cardOne && 1 < len(vals) {
+			return fmt.Errorf("datoms: %v is cardinality one but there are %v values", attr.Ident, len(vals))
+		}
+		ids := make([]any, len(vals))
+		for i, rv := range vals {
+			if ids[i], err = tx.expandValue(id, attr, rv); err != nil {
+				return err
 			}
-		case reflect.String:
-			if len(vf.String()) == 0 {
-				continue
+		}
+		if diff {
+			if err = tx.txDiff(id, attr, ids); err != nil {
+				return err
 			}
+			continue
 		}
-		attr, err := tx.resolveAttr(tf.Ident)
+		for _, v := range ids {
+			if err = tx.emit(id, attr, v, 1); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// fieldValues are the values of a struct field. A slice is many values, nil
+// pointers and slices, empty strings and zero structs are no value.
+func fieldValues(ct *ContractType, vf reflect.Value) []reflect.Value {
+	switch ct.Kind {
+	case reflect.Pointer:
+		if vf.IsNil() {
+			return nil
+		}
+	case reflect.Slice:
+		vals := make([]reflect.Value, 0, vf.Len())
+		for i := 0; i < vf.Len(); i++ {
+			if elem := vf.Index(i); !(elem.Kind() == reflect.Pointer && elem.IsNil()) {
+				vals = append(vals, elem)
+			}
+		}
+		return vals
+	case reflect.String:
+		if len(vf.String()) == 0 {
+			return nil
+		}
+	case reflect.Struct:
+		if ct.Entity && vf.IsZero() {
+			return nil
+		}
+	}
+	return []reflect.Value{vf}
+}
+
+// expandValue is the value of a field. Structs that embed base.Entity are
+// nested entities and the value is a ref to them, a nested entity without an
+// ID is a new entity, in the partition of e if attr is a component. Pointers
+// to entities that have been expanded already are refs to the same entity.
+// Nested entities are only asserted, never diffed, so a struct with just an
+// ID is a plain ref.
+func (tx *txBuilder) expandValue(e int64, attr schema.Attr, rv reflect.Value) (any, error) {
+	var ptr uintptr
+	if rv.Kind() == reflect.Pointer {
+		ptr = rv.Pointer()
+		rv = rv.Elem()
+	}
+	if !(rv.Kind() == reflect.Struct && contractType(rv.Type()).Entity) {
+		return canonical(rv), nil
+	}
+	if attr.ValueType != tx.refType {
+		return nil, fmt.Errorf("datoms: %v is not a ref but the value is an entity", attr.Ident)
+	}
+	if id, ok := tx.expanded[ptr]; ok && ptr != 0 {
+		return id, nil
+	}
+
+	var entid base.Entid = rv.Interface().(base.EntityLike)
+	if entid.Zero() {
+		part := schema.DbPartUser
+		if attr.IsComponent {
+			partId, _ := pack.Unpack(e)
+			if ident, ok := tx.schema.Ident(partId); ok {
+				part = ident
+			}
+		}
+		entid = base.NewTempId(part)
+	}
+	id, err := tx.resolveEntid(entid)
+	if err != nil {
+		return nil, err
+	}
+	if ptr != 0 {
+		tx.expanded[ptr] = id
+	}
+	if err = tx.expandFields(id, rv, false); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// canonical is a field value as the Go type of its value type, the integer
+// and float types that schema.FromStructs accepts are int64 and float64
+func canonical(rv reflect.Value) any {
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return int64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.String:
+		return rv.String()
+	}
+	return rv.Interface()
+}
+
+// txDiff emits the assertions and retractions that make vals the values of
+// the attribute of an entity
+func (tx *txBuilder) txDiff(e int64, attr schema.Attr, vals []any) (err error) {
+	want := make([]any, 0, len(vals))
+	for _, v := range vals {
+		if attr.ValueType == tx.refType {
+			if v, err = tx.resolveRef(v); err != nil {
+				return
+			}
+		}
+		if !containsValue(want, v) {
+			want = append(want, v)
+		}
+	}
+
+	var have []any
+	if 0 < e {
+		tx.db.Datoms(base.EAVT, e, attr.Id)(func(d base.Datom) bool {
+			have = append(have, d.V)
+			return true
+		})
+	}
+
+	for _, v := range have {
+		if !containsValue(want, v) {
+			if err = tx.emit(e, attr, v, 0); err != nil {
+				return
+			}
+		}
+	}
+	for _, v := range want {
+		if !containsValue(have, v) {
+			if err = tx.emit(e, attr, v, 1); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func containsValue(vals []any, v any) bool {
+	for _, x := range vals {
+		if reflect.TypeOf(x) == reflect.TypeOf(v) && sort.CompareValue(x, v) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// txEntity emits the datoms of an entity map and of the entities nested in it
+func (tx *txBuilder) txEntity(e base.TxEntity) (id int64, err error) {
+	var entid base.Entid
+	switch v := e[schema.DbId].(type) {
+	case nil:
+		entid = base.NewTempId(schema.DbPartUser)
+	case int64:
+		entid = base.Entity{Id: v}
+	case symbol.Keyword:
+		entid = base.Entity{Ident: v}
+	case string:
+		entid = base.NamedTempId(v)
+	case base.Entid:
+		entid = v
+	default:
+		return 0, fmt.Errorf("datoms: invalid :db/id %v", v)
+	}
+
+	if id, err = tx.resolveEntid(entid); err != nil {
+		return 0, err
+	}
+
+	// attributes in a stable order so that the same map makes the same datoms
+	attrs := make([]symbol.Keyword, 0, len(e))
+	for a := range e {
+		if a != schema.DbId {
+			attrs = append(attrs, a)
+		}
+	}
+	slices.SortFunc(attrs, func(x, y symbol.Keyword) int { return strings.Compare(x.String(), y.String()) })
+
+	for _, a := range attrs {
+		attr, err := tx.resolveAttr(a)
 		if err != nil {
 			return 0, err
 		}
-		tx.emit(id, attr, vf.Interface(), 1)
+		vals := []any{e[a]}
+		if rv := reflect.ValueOf(e[a]); rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+			if attr.Cardinality == tx.cardOne {
+				return 0, fmt.Errorf("datoms: %v is cardinality one but the value is a %T", a, e[a])
+			}
+			vals = make([]any, rv.Len())
+			for i := range vals {
+				vals[i] = rv.Index(i).Interface()
+			}
+		}
+		for _, v := range vals {
+			if nested, ok := v.(base.TxEntity); ok {
+				if attr.ValueType != tx.refType {
+					return 0, fmt.Errorf("datoms: %v is not a ref but the value is an entity", a)
+				}
+				if v, err = tx.txEntity(nested); err != nil {
+					return 0, err
+				}
+			}
+			if err = tx.emit(id, attr, v, 1); err != nil {
+				return 0, err
+			}
+		}
 	}
 
 	return id, nil
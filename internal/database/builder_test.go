@@ -16,5 +16,5 @@ func TestBuilder(t *testing.T) {
 
 	tx.init(NewTestDatabase())
 
-	tx.txExpand(nil, foo{Foo: "foo"})
+	tx.txExpand(nil, foo{Foo: "foo"}, false)
 }
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/leidegre/datoms/internal/base"
+)
+
+// Connection is a mutable reference to the latest value of a database.
+// Transactions through a connection are applied one at a time.
+type Connection struct {
+	tx sync.Mutex // serializes transactions and their notifications
+
+	mu        sync.RWMutex
+	db        Interface
+	listeners map[int]func(Transaction)
+	next      int
+}
+
+// Connect makes a connection that starts out at db
+func Connect(db Interface) *Connection {
+	return &Connection{db: db, listeners: make(map[int]func(Transaction))}
+}
+
+// Db is the latest value of the database
+func (conn *Connection) Db() Interface {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	return conn.db
+}
+
+// Transact applies transaction data to the latest value of the database and
+// notifies the listeners before it returns
+func (conn *Connection) Transact(txData []base.TxData) (Transaction, error) {
+	conn.tx.Lock()
+	defer conn.tx.Unlock()
+
+	return conn.apply(conn.Db(), txData)
+}
+
+// TransactFunc is like Transact but the transaction data is made by fn from
+// the latest value of the database, no other transaction is applied in
+// between. If fn returns no transaction data nothing is transacted and the
+// database before and after is the latest value.
+func (conn *Connection) TransactFunc(fn func(db Interface) ([]base.TxData, error)) (Transaction, error) {
+	conn.tx.Lock()
+	defer conn.tx.Unlock()
+
+	db := conn.Db()
+	txData, err := fn(db)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if len(txData) == 0 {
+		return Transaction{DbBefore: db, DbAfter: db}, nil
+	}
+
+	return conn.apply(db, txData)
+}
+
+// apply transacts and notifies the listeners, conn.tx must be locked
+func (conn *Connection) apply(db Interface, txData []base.TxData) (Transaction, error) {
+	tx, err := db.With(txData)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	conn.mu.Lock()
+	conn.db = tx.DbAfter
+	ids := make([]int, 0, len(conn.listeners))
+	for id := range conn.listeners {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids) // the order that they started listening
+	listeners := make([]func(Transaction), len(ids))
+	for i, id := range ids {
+		listeners[i] = conn.listeners[id]
+	}
+	conn.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(tx)
+	}
+
+	return tx, nil
+}
+
+// Listen calls fn after every transaction in the order that they are
+// applied, until cancel is called. The database after the transaction is the
+// latest value of the database when fn is called. fn must not transact
+// through the connection.
+func (conn *Connection) Listen(fn func(tx Transaction)) (cancel func()) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	id := conn.next
+	conn.next++
+	conn.listeners[id] = fn
+	return func() {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		delete(conn.listeners, id)
+	}
+}
+
+// Watch is like Listen but it also reports the latest value of the database
+// at the time that fn starts listening. No transaction is applied in between.
+func (conn *Connection) Watch(fn func(tx Transaction)) (db Interface, cancel func()) {
+	conn.tx.Lock()
+	defer conn.tx.Unlock()
+	return conn.Db(), conn.Listen(fn)
+}
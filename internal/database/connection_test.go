@@ -0,0 +1,44 @@
+package database_test
+
+import (
+	"testing"
+
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/testutil"
+)
+
+func TestConnection(t *testing.T) {
+	conn := database.Connect(database.NewTestDatabase())
+
+	var (
+		before []database.Interface
+		after  []database.Interface
+	)
+	db, cancel := conn.Watch(func(tx database.Transaction) {
+		before = append(before, tx.DbBefore)
+		after = append(after, conn.Db())
+	})
+
+	tx1, err := conn.Transact([]base.TxData{database.Add(base.NewTempId(schema.DbPartUser), schema.DbDoc, "foo")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx2, err := conn.Transact([]base.TxData{database.Add(base.NewTempId(schema.DbPartUser), schema.DbDoc, "bar")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+
+	if _, err := conn.Transact([]base.TxData{database.Add(base.NewTempId(schema.DbPartUser), schema.DbDoc, "baz")}); err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.AreEqual(t, 2, len(before))
+	testutil.AreEqual(t, db, before[0])
+	testutil.AreEqual(t, tx1.DbAfter, before[1])
+	testutil.AreEqual(t, tx1.DbAfter, after[0])
+	testutil.AreEqual(t, tx2.DbAfter, after[1])
+}
@@ -2,14 +2,18 @@ package database
 
 import (
 	"reflect"
+	"strings"
+	"sync"
 
 	"github.com/leidegre/datoms/cow"
+	"github.com/leidegre/datoms/internal/base"
 	"github.com/leidegre/datoms/internal/schema"
 	"github.com/leidegre/datoms/symbol"
 )
 
 type ContractType struct {
 	Kind   reflect.Kind
+	Entity bool            // Entity is true for structs that embed base.Entity, their values are refs
 	Elem   *ContractType   // Elem is nil for all kinds except Pointer, Slice
 	Fields []ContractField // Fields are nil for all kinds except Struct
 }
@@ -21,29 +25,49 @@ type ContractField struct {
 	Ident symbol.Keyword
 }
 
+var (
+	contractTypes  sync.Map // reflect.Type -> *ContractType
+	entityLikeType = reflect.TypeOf((*base.EntityLike)(nil)).Elem()
+)
+
 func contractType(t reflect.Type) *ContractType {
-	// todo: recursive type
+	if c, ok := contractTypes.Load(t); ok {
+		return c.(*ContractType)
+	}
+	c := contractTypeOf(t, make(map[reflect.Type]*ContractType))
+	contractTypes.Store(t, c)
+	return c
+}
+
+// contractTypeOf uses seen for recursive types, like a struct with a field
+// that is a pointer to the same struct
+func contractTypeOf(t reflect.Type, seen map[reflect.Type]*ContractType) *ContractType {
 	// todo: validate that you don't reuse ident, each ident must be unique per entity
+	if c, ok := seen[t]; ok {
+		return c
+	}
 	kind := t.Kind()
+	c := &ContractType{Kind: kind}
+	seen[t] = c
 	switch kind {
 	case reflect.Struct:
 		// some struct types are terminal, like time.Time and symbol.Keyword
-		return &ContractType{Kind: kind, Fields: contractFields(nil, t, nil)}
+		c.Entity = t.Implements(entityLikeType)
+		c.Fields = contractFields(nil, t, nil, seen)
 	case reflect.Pointer, reflect.Slice:
-		return &ContractType{Kind: kind, Elem: contractType(t.Elem())}
-	default:
-		return &ContractType{Kind: kind}
+		c.Elem = contractTypeOf(t.Elem(), seen)
 	}
+	return c
 }
 
-func contractFields(fields []ContractField, st reflect.Type, index []int) []ContractField {
+func contractFields(fields []ContractField, st reflect.Type, index []int, seen map[reflect.Type]*ContractType) []ContractField {
 	for i, end := 0, st.NumField(); i < end; i++ {
 		sf := st.Field(i)
 		if !sf.IsExported() {
 			continue
 		}
 		kind := sf.Type.Kind()
-		identTag := sf.Tag.Get("ident")
+		identTag, _, _ := strings.Cut(sf.Tag.Get("ident"), ",") // options are for the schema
 		if 0 < len(identTag) {
 			ident := symbol.For(identTag)
 			switch ident {
@@ -52,11 +76,11 @@ func contractFields(fields []ContractField, st reflect.Type, index []int) []Cont
 			}
 			fields = append(fields, ContractField{
 				Kind:  kind,
-				Type:  contractType(sf.Type),
+				Type:  contractTypeOf(sf.Type, seen),
 				Index: cow.Append(index, sf.Index...),
 				Ident: ident})
 		} else if kind == reflect.Struct && sf.Anonymous {
-			fields = contractFields(fields, sf.Type, cow.Append(index, sf.Index...))
+			fields = contractFields(fields, sf.Type, cow.Append(index, sf.Index...), seen)
 		}
 	}
 	return fields
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"slices"
+
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/sort"
+)
+
+// Cursor is a position in an index that can move forward and seek. Unlike
+// iter.Seq a cursor doesn't have to enumerate the datoms that it skips.
+type Cursor interface {
+	// Seek moves to the first datom that is at or after the components,
+	// the components are given in index order
+	Seek(components ...any)
+
+	// Next moves to the next datom
+	Next()
+
+	// Valid reports whether the cursor is at a datom
+	Valid() bool
+
+	// Key is the datom that the cursor is at
+	Key() base.Datom
+}
+
+// sliceCursor is a cursor over datoms that are sorted in history order
+type sliceCursor struct {
+	index   base.Index
+	data    []base.Datom
+	i       int
+	asOf    int64
+	history bool
+}
+
+// NewCursor makes a cursor over data that is sorted in history order for
+// index. Datoms after asOf are skipped unless asOf is zero and unless history
+// is set only current datoms are visible.
+func NewCursor(index base.Index, data []base.Datom, asOf int64, history bool) Cursor {
+	c := &sliceCursor{index: index, data: data, asOf: asOf, history: history}
+	c.settle()
+	return c
+}
+
+// settle moves forward to the first visible datom
+func (c *sliceCursor) settle() {
+	for c.i < len(c.data) {
+		d := c.data[c.i]
+		if c.asOf != 0 && c.asOf < d.Tx() {
+			c.i++
+			continue
+		}
+		// the first datom of every E, A and V is the latest and it decides
+		// whether the value is current
+		if c.history || d.Assertion() {
+			return
+		}
+		c.skip()
+	}
+}
+
+// skip moves past the datoms that have the same E, A and V
+func (c *sliceCursor) skip() {
+	d := c.data[c.i]
+	for c.i++; c.i < len(c.data) && sort.CompareEAV(c.data[c.i], d) == 0; c.i++ {
+	}
+}
+
+func (c *sliceCursor) Seek(components ...any) {
+	c.i, _ = slices.BinarySearchFunc(c.data, sort.Target(c.index, components), sort.ComparePrefix(c.index, len(components)))
+	c.settle()
+}
+
+func (c *sliceCursor) Next() {
+	if c.history {
+		c.i++
+	} else {
+		c.skip()
+	}
+	c.settle()
+}
+
+func (c *sliceCursor) Valid() bool { return c.i < len(c.data) }
+
+func (c *sliceCursor) Key() base.Datom { return c.data[c.i] }
@@ -1,26 +1,209 @@
 package database
 
 import (
+	"fmt"
+
+	"github.com/leidegre/datoms/hash"
+	hamt "github.com/leidegre/datoms/immutable/hashmap"
 	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/pack"
 	"github.com/leidegre/datoms/internal/schema"
 	"github.com/leidegre/datoms/iter"
 )
 
 type Transaction struct {
-	DbBefore Interface
-	DbAfter  Interface
-	TxData   []base.Datom
-	TempIds  map[int64]int64
+	DbBefore  Interface
+	DbAfter   Interface
+	TxData    []base.Datom
+	TempIds   map[int64]int64
+	TempNames map[string]int64 // named temp IDs
 }
 
 type Interface interface {
 	T() (baseT, nextT int64)
 
+	// NextId is the next unused entity ID within partition
+	NextId(part int64) int64
+
 	Schema() schema.Interface
 
 	SeekDatoms(index base.Index, components ...any) iter.Seq[base.Datom]
 
 	Datoms(index base.Index, components ...any) iter.Seq[base.Datom]
 
+	// Cursor returns an unpositioned cursor over index, Seek positions it
+	Cursor(index base.Index) Cursor
+
+	// AsOf returns the database as it was at transaction t
+	AsOf(t int64) Interface
+
+	// History returns a database that has every assertion and retraction
+	History() Interface
+
+	// With applies transaction data to the database, view filters such as
+	// AsOf and History do not carry over to the database after
 	With(txData []base.TxData) (Transaction, error)
 }
+
+// Stats are statistics about the current datoms of an attribute
+type Stats struct {
+	Datoms   int64 // datoms
+	Values   int64 // distinct values
+	Entities int64 // distinct entities
+}
+
+// Statistics is implemented by storage that keeps attribute statistics. The
+// statistics are for the latest database value, views such as AsOf and
+// History report the same statistics.
+type Statistics interface {
+	Stats(a int64) Stats
+}
+
+// Entity IDs below this are reserved for the bootstrapping part, every
+// partition starts allocating entity IDs from here.
+const FirstId = 1000
+
+// Partitions tracks entity ID allocation per partition so that entities that
+// belong to the same partition sit close together in EAVT.
+type Partitions struct {
+	next hamt.Persistent[int64, int64]
+}
+
+func (p Partitions) NextId(part int64) int64 {
+	if next, ok := p.next.Get(part, hash.Uint64(uint64(part))); ok {
+		return next
+	}
+	return FirstId
+}
+
+// With advances the partitions past the entity IDs that were allocated for temp IDs.
+func (p Partitions) With(tempIds map[int64]int64) Partitions {
+	for _, id := range tempIds {
+		part, ent := pack.Unpack(id)
+		if p.NextId(part) <= ent {
+			p.next = p.next.Set(part, hash.Uint64(uint64(part)), ent+1)
+		}
+	}
+	return p
+}
+
+// eav is a map key of a datom
+type eav struct {
+	e, a int64
+	v    string
+}
+
+func eavOf(d base.Datom) eav { return eav{d.E, d.A, ValueKey(d.V)} }
+
+// ValueKey is an encoding of a value that can be used as a map key, values
+// have the same key if they are equal like sort.CompareValue
+func ValueKey(v any) string {
+	b, ok := base.AppendValue(nil, v)
+	if !ok {
+		panic(fmt.Sprintf("datoms: %T is not a value type", v))
+	}
+	return string(b)
+}
+
+// NoHistory removes the retractions of :db/noHistory attributes from
+// transaction data. The storage layer should also drop the datoms that
+// match the drop predicate from its history indexes when it indexes. The
+// drop predicate is nil if there's nothing to drop.
+func NoHistory(s schema.Interface, txData []base.Datom) (data []base.Datom, drop func(d base.Datom) bool) {
+	var retracted map[eav]bool
+	for _, d := range txData {
+		if d.Retraction() {
+			if attr, ok := s.Attr(d.A); ok && attr.NoHistory {
+				if retracted == nil {
+					retracted = make(map[eav]bool)
+				}
+				retracted[eavOf(d)] = true
+				continue
+			}
+		}
+		data = append(data, d)
+	}
+	if retracted != nil {
+		drop = func(d base.Datom) bool { return retracted[eavOf(d)] }
+	}
+	return
+}
+
+type excision struct {
+	target  int64
+	attrs   map[int64]bool
+	beforeT int64
+}
+
+// Excised finds the excisions in transaction data. The storage layer must
+// drop the datoms that match the drop predicate from its indexes and its
+// log. The drop predicate is nil if there's nothing to drop.
+func Excised(s schema.Interface, txData []base.Datom) (drop func(d base.Datom) bool) {
+	var (
+		exciseId, _  = s.Id(schema.DbExcise)
+		attrsId, _   = s.Id(schema.DbExciseAttrs)
+		beforeTId, _ = s.Id(schema.DbExciseBeforeT)
+		excisions    map[int64]*excision
+	)
+
+	get := func(e int64) *excision {
+		if excisions == nil {
+			excisions = make(map[int64]*excision)
+		}
+		ex, ok := excisions[e]
+		if !ok {
+			ex = &excision{}
+			excisions[e] = ex
+		}
+		return ex
+	}
+
+	for _, d := range txData {
+		if !d.Assertion() {
+			continue
+		}
+		switch d.A {
+		case exciseId:
+			get(d.E).target = d.V.(int64)
+		case attrsId:
+			ex := get(d.E)
+			if ex.attrs == nil {
+				ex.attrs = make(map[int64]bool)
+			}
+			ex.attrs[d.V.(int64)] = true
+		case beforeTId:
+			get(d.E).beforeT = d.V.(int64)
+		}
+	}
+
+	if excisions == nil {
+		return nil
+	}
+
+	var (
+		entities []*excision
+		attrs    []*excision
+	)
+
+	for _, ex := range excisions {
+		if _, ok := s.Attr(ex.target); ok {
+			attrs = append(attrs, ex)
+		} else {
+			entities = append(entities, ex)
+		}
+	}
+
+	return func(d base.Datom) bool {
+		for _, ex := range entities {
+			if d.E == ex.target && (ex.attrs == nil || ex.attrs[d.A]) && (ex.beforeT == 0 || d.Tx() < ex.beforeT) {
+				return true
+			}
+		}
+		for _, ex := range attrs {
+			if d.A == ex.target && (ex.beforeT == 0 || d.Tx() < ex.beforeT) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -3,9 +3,7 @@ package database
 import (
 	"slices"
 
-	"github.com/leidegre/datoms/cow"
 	"github.com/leidegre/datoms/internal/base"
-	"github.com/leidegre/datoms/internal/iterutil"
 	"github.com/leidegre/datoms/internal/schema"
 	"github.com/leidegre/datoms/internal/sort"
 	"github.com/leidegre/datoms/iter"
@@ -14,38 +12,90 @@ import (
 // A basic and NOT scalable database implementation for testing.
 type TestDatabase struct {
 	baseT, nextT int64
+	parts        Partitions
 	data         []base.Datom
 	schema       *schema.Schema
+	asOf         int64
+	history      bool
 }
 
 func (db *TestDatabase) T() (int64, int64) { return db.baseT, db.nextT }
 
+func (db *TestDatabase) NextId(part int64) int64 { return db.parts.NextId(part) }
+
 func (db *TestDatabase) Schema() schema.Interface { return db.schema }
 
 func (db *TestDatabase) SeekDatoms(index base.Index, components ...any) iter.Seq[base.Datom] {
-	cmp := sort.CompareHistory(index)
-	data := cow.ShallowCopy(db.data)
-	slices.SortFunc(data, cmp)
-	i, _ := slices.BinarySearchFunc(data, sort.Target(index, components), cmp)
-	return iterutil.Live(iter.Forward(data[i:]))
+	return func(yield func(d base.Datom) bool) {
+		c := db.Cursor(index)
+		for c.Seek(components...); c.Valid(); c.Next() {
+			if !yield(c.Key()) {
+				return
+			}
+		}
+	}
+}
+
+func (db *TestDatabase) Cursor(index base.Index) Cursor {
+	var data []base.Datom
+	if index == base.VAET {
+		// VAET only has refs
+		refType, _ := db.schema.Id(schema.DbTypeRef)
+		for _, d := range db.data {
+			if attr, _ := db.schema.Attr(d.A); attr.ValueType == refType {
+				data = append(data, d)
+			}
+		}
+	} else {
+		data = slices.Clone(db.data)
+	}
+	slices.SortFunc(data, sort.CompareHistory(index))
+	return NewCursor(index, data, db.asOf, db.history)
 }
 
 func (db *TestDatabase) Datoms(index base.Index, components ...any) iter.Seq[base.Datom] {
 	return iter.TakeWhile(db.SeekDatoms(index, components...), sort.TakeWhile(index, components))
 }
 
+func (db *TestDatabase) AsOf(t int64) Interface {
+	tmp := *db
+	tmp.asOf = t
+	return &tmp
+}
+
+func (db *TestDatabase) History() Interface {
+	tmp := *db
+	tmp.history = true
+	return &tmp
+}
+
 func (db *TestDatabase) With(txData []base.TxData) (Transaction, error) {
-	baseT, nextT, data, tempIds, err := Transact(db, txData)
+	curr := *db
+	curr.asOf, curr.history = 0, false
+
+	baseT, nextT, data, tempIds, tempNames, err := Transact(&curr, txData)
 
 	if err != nil {
 		return Transaction{}, err
 	}
 
+	s := db.schema.With(data)
+	indexed, noHistory := NoHistory(s, data)
+	excised := Excised(s, data)
+	var hist []base.Datom
+	for _, d := range db.data {
+		if noHistory != nil && noHistory(d) || excised != nil && excised(d) {
+			continue
+		}
+		hist = append(hist, d)
+	}
+
 	return Transaction{
-		DbBefore: db,
-		DbAfter:  &TestDatabase{baseT, nextT, cow.Append(db.data, data...), db.schema.With(data)},
-		TxData:   data,
-		TempIds:  tempIds,
+		DbBefore:  db,
+		DbAfter:   &TestDatabase{baseT, nextT, db.parts.With(tempIds), append(hist, indexed...), s, 0, false},
+		TxData:    data,
+		TempIds:   tempIds,
+		TempNames: tempNames,
 	}, nil
 }
 
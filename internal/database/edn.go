@@ -0,0 +1,143 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/leidegre/datoms/edn"
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/symbol"
+)
+
+var (
+	dbAdd     = symbol.For(":db/add")
+	dbRetract = symbol.For(":db/retract")
+)
+
+// ReadTxData reads transaction data from EDN text, every vector of
+// transaction data in the text is read
+func ReadTxData(data []byte) ([]base.TxData, error) {
+	vals, err := edn.ReadAll(data)
+	if err != nil {
+		return nil, err
+	}
+	var txData []base.TxData
+	for _, v := range vals {
+		tmp, err := TxDataFromEDN(v)
+		if err != nil {
+			return nil, err
+		}
+		txData = append(txData, tmp...)
+	}
+	return txData, nil
+}
+
+// TxDataFromEDN converts EDN transaction data like
+//
+//	[[:db/add "fred" :person/name "Fred"]
+//	 [:db/retract 17592186045418 :person/name "Ethel"]
+//	 {:db/id "ethel" :person/name "Ethel" :person/friend "fred"}]
+//
+// The entity of :db/add and :db/retract is an entity ID, an ident or a named
+// temp ID. Strings are named temp IDs where a ref is expected.
+func TxDataFromEDN(v any) ([]base.TxData, error) {
+	forms, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("datoms: transaction data is a vector, not %T", v)
+	}
+	txData := make([]base.TxData, 0, len(forms))
+	for _, form := range forms {
+		switch form := form.(type) {
+		case []any:
+			if len(form) != 4 {
+				return nil, fmt.Errorf("datoms: expected [op e a v] in transaction data, got %v", form)
+			}
+			e, err := entidFromEDN(form[1])
+			if err != nil {
+				return nil, err
+			}
+			a, ok := form[2].(symbol.Keyword)
+			if !ok {
+				return nil, fmt.Errorf("datoms: attribute %v is not a keyword", form[2])
+			}
+			v, err := valueFromEDN(form[3])
+			if err != nil {
+				return nil, err
+			}
+			switch form[0] {
+			case dbAdd:
+				txData = append(txData, base.TxAdd{E: e, A: a, V: v})
+			case dbRetract:
+				txData = append(txData, base.TxRetract{E: e, A: a, V: v})
+			default:
+				return nil, fmt.Errorf("datoms: unknown operation %v in transaction data", form[0])
+			}
+		case map[any]any:
+			e, err := entityFromEDN(form)
+			if err != nil {
+				return nil, err
+			}
+			txData = append(txData, e)
+		default:
+			return nil, fmt.Errorf("datoms: unexpected %T in transaction data", form)
+		}
+	}
+	return txData, nil
+}
+
+func entidFromEDN(v any) (base.Entid, error) {
+	switch v := v.(type) {
+	case int64:
+		return base.Entity{Id: v}, nil
+	case symbol.Keyword:
+		return base.Entity{Ident: v}, nil
+	case string:
+		return base.NamedTempId(v), nil
+	default:
+		return nil, fmt.Errorf("datoms: cannot resolve entity %v", v)
+	}
+}
+
+func entityFromEDN(m map[any]any) (base.TxEntity, error) {
+	e := make(base.TxEntity, len(m))
+	for k, v := range m {
+		a, ok := k.(symbol.Keyword)
+		if !ok {
+			return nil, fmt.Errorf("datoms: attribute %v is not a keyword", k)
+		}
+		if a == schema.DbId {
+			e[a] = v
+			continue
+		}
+		var err error
+		if e[a], err = valueFromEDN(v); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// valueFromEDN converts maps to entities and collections to slices
+func valueFromEDN(v any) (any, error) {
+	var elems []any
+	switch v := v.(type) {
+	case map[any]any:
+		return entityFromEDN(v)
+	case []any:
+		elems = v
+	case edn.List:
+		elems = v
+	case edn.Set:
+		elems = v
+	default:
+		return v, nil
+	}
+	vals := make([]any, len(elems))
+	for i, elem := range elems {
+		var err error
+		if vals[i], err = valueFromEDN(elem); err != nil {
+			return nil, err
+		}
+	}
+	return vals, nil
+}
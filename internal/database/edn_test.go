@@ -0,0 +1,138 @@
+package database_test
+
+import (
+	"testing"
+
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/symbol"
+	"github.com/leidegre/datoms/testutil"
+)
+
+const schemaEDN = `
+; attributes
+[{:db/id "name" :db/ident :person/name :db/valueType :db.type/string :db/cardinality :db.cardinality/one}
+ {:db/id "age" :db/ident :person/age :db/valueType :db.type/long :db/cardinality :db.cardinality/one}
+ {:db/id "friends" :db/ident :person/friends :db/valueType :db.type/ref :db/cardinality :db.cardinality/many}
+ {:db/id :db.part/db :db.install/attribute ["name" "age" "friends"]}]
+`
+
+const fixtureEDN = `
+[{:db/id "fred" :person/name "Fred" :person/friends ["ethel" {:person/name "Lucy"}]}
+ {:db/id "ethel" :person/name "Ethel" :person/friends #{"fred"}}
+ [:db/add "fred" :person/age 42]]
+`
+
+func TestReadTxData(t *testing.T) {
+	txData, err := database.ReadTxData([]byte(schemaEDN))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := database.NewTestDatabase().With(txData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txData, err = database.ReadTxData([]byte(fixtureEDN))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err = tx.DbAfter.With(txData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := tx.DbAfter
+	attr := func(ident string) int64 {
+		id, ok := db.Schema().Id(symbol.For(ident))
+		if !ok {
+			t.Fatalf("no attribute %v", ident)
+		}
+		return id
+	}
+	values := func(e int64, a string) []any {
+		var vals []any
+		db.Datoms(base.EAVT, e, attr(a))(func(d base.Datom) bool {
+			vals = append(vals, d.V)
+			return true
+		})
+		return vals
+	}
+
+	fred, ethel := tx.TempNames["fred"], tx.TempNames["ethel"]
+	testutil.AreEqual(t, true, 0 < fred && 0 < ethel)
+
+	testutil.AreEqualSlice(t, []any{"Fred"}, values(fred, ":person/name"))
+	testutil.AreEqualSlice(t, []any{int64(42)}, values(fred, ":person/age"))
+	testutil.AreEqualSlice(t, []any{fred}, values(ethel, ":person/friends"))
+
+	friends := values(fred, ":person/friends")
+	testutil.AreEqual(t, 2, len(friends))
+	for _, friend := range friends {
+		if friend != ethel {
+			testutil.AreEqualSlice(t, []any{"Lucy"}, values(friend.(int64), ":person/name"))
+		}
+	}
+}
+
+func TestTxEntity(t *testing.T) {
+	txData, err := database.ReadTxData([]byte(schemaEDN))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := database.NewTestDatabase().With(txData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		name    = symbol.For(":person/name")
+		age     = symbol.For(":person/age")
+		friends = symbol.For(":person/friends")
+	)
+
+	for _, e := range []base.TxEntity{
+		{name: []any{"Fred", "Freddy"}},    // cardinality one
+		{age: base.TxEntity{name: "Fred"}}, // not a ref
+		{symbol.For(":person/unknown"): 1}, // not an attribute
+		{friends: base.TxEntity{symbol.For(":db/id"): 1.5}},
+	} {
+		if _, err := tx.DbAfter.With([]base.TxData{e}); err == nil {
+			t.Errorf("expected an error for %v", e)
+		}
+	}
+
+	for _, s := range []string{
+		`{:db/id "fred"}`,
+		`[[:db/assert "fred" :person/name "Fred"]]`,
+		`[[:db/add "fred" :person/name]]`,
+		`[[:db/add 1.5 :person/name "Fred"]]`,
+		`[{"name" "Fred"}]`,
+	} {
+		if _, err := database.ReadTxData([]byte(s)); err == nil {
+			t.Errorf("expected an error for %v", s)
+		}
+	}
+}
+
+func TestDanglingTempName(t *testing.T) {
+	txData, err := database.ReadTxData([]byte(schemaEDN))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := database.NewTestDatabase().With(txData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// "ehtel" is a typo, it's only ever a value
+	txData, err = database.ReadTxData([]byte(`
+[{:db/id "fred" :person/name "Fred" :person/friends "ehtel"}
+ {:db/id "ethel" :person/name "Ethel"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.DbAfter.With(txData); err == nil {
+		t.Error("expected an error for a temp ID that is never an entity")
+	}
+}
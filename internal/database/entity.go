@@ -0,0 +1,232 @@
+package database
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/symbol"
+)
+
+// LazyEntity is a read-only view of an entity in a database value. The
+// attributes are read from the EAVT index as they are asked for and then
+// kept, the entities that ref attributes refer to are further lazy entities.
+type LazyEntity struct {
+	db      Interface
+	id      int64
+	mu      sync.Mutex
+	attrs   map[symbol.Keyword]any // nil is no value
+	touched bool
+}
+
+// Entity returns a lazy entity, e is resolved like ResolveEntid but the
+// entity doesn't have to have any datoms
+func Entity(db Interface, e any) (*LazyEntity, error) {
+	id, err := ResolveEntid(db, e)
+	if err != nil {
+		return nil, err
+	}
+	return newLazyEntity(db, id), nil
+}
+
+// ResolveEntid resolves e against a database value the way the transactor
+// resolves refs. e is an entity ID, an ident, an entity like base.Entity or a
+// lookup ref, the ident and value of a unique attribute like
+// []any{:person/email "fred@example.com"}. Temp IDs cannot be resolved.
+func ResolveEntid(db Interface, e any) (int64, error) {
+	s := db.Schema()
+	switch e := e.(type) {
+	case int64:
+		return e, nil
+	case symbol.Keyword:
+		if id, ok := s.Id(e); ok {
+			return id, nil
+		}
+	case base.EntityLike:
+		id, ident := base.EntityIdentities(e)
+		if id != 0 {
+			return id, nil
+		}
+		// this includes the old idents of renamed entities
+		if id, ok := s.Id(ident); ok {
+			return id, nil
+		}
+	case []any:
+		return lookup(db, e)
+	}
+	return 0, base.ErrCannotResolve
+}
+
+func lookup(db Interface, ref []any) (id int64, err error) {
+	if len(ref) != 2 {
+		return 0, base.ErrCannotResolve
+	}
+	a, ok := ref[0].(symbol.Keyword)
+	if !ok {
+		return 0, base.ErrCannotResolve
+	}
+	attr, ok := db.Schema().AttrKeyword(a)
+	if !ok {
+		return 0, base.ErrAttributeNotFound
+	}
+	if attr.Unique == 0 {
+		return 0, fmt.Errorf("datoms: lookup ref attribute %v is not unique", a)
+	}
+	err = base.ErrCannotResolve
+	db.Datoms(base.AVET, attr.Id, ref[1])(func(d base.Datom) bool {
+		id, err = d.E, nil
+		return false
+	})
+	return
+}
+
+func newLazyEntity(db Interface, id int64) *LazyEntity {
+	return &LazyEntity{db: db, id: id, attrs: make(map[symbol.Keyword]any)}
+}
+
+func (e *LazyEntity) Id() int64 { return e.id }
+
+// Db is the database value that the entity is read from
+func (e *LazyEntity) Db() Interface { return e.db }
+
+// Get returns the value of an attribute. Values of cardinality many
+// attributes are []any and ref values are *LazyEntity. A reverse attribute
+// like :person/_friend navigates refs backwards and returns the referring
+// entities as []any, or the one owner of a component.
+func (e *LazyEntity) Get(a symbol.Keyword) (any, bool) {
+	if a == schema.DbId {
+		return e.id, true
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if v, ok := e.attrs[a]; ok || e.touched && !isReverse(a) {
+		return v, v != nil
+	}
+
+	v := e.get(a)
+	e.attrs[a] = v
+	return v, v != nil
+}
+
+func isReverse(a symbol.Keyword) bool {
+	_, ok := ReverseAttr(a)
+	return ok
+}
+
+// ref is a lazy entity as a value
+func (e *LazyEntity) ref(id int64) (any, error) { return newLazyEntity(e.db, id), nil }
+
+func (e *LazyEntity) get(a symbol.Keyword) any {
+	s := e.db.Schema()
+
+	if ident, ok := ReverseAttr(a); ok {
+		attr, ok := s.AttrKeyword(ident)
+		if refType, _ := s.Id(schema.DbTypeRef); !ok || attr.ValueType != refType {
+			return nil
+		}
+		var datoms []base.Datom
+		e.db.Datoms(base.VAET, e.id, attr.Id)(func(d base.Datom) bool {
+			datoms = append(datoms, d)
+			return true
+		})
+		v, _ := ReverseValue(attr, datoms, e.ref)
+		return v
+	}
+
+	attr, ok := s.AttrKeyword(a)
+	if !ok {
+		return nil
+	}
+	var datoms []base.Datom
+	e.db.Datoms(base.EAVT, e.id, attr.Id)(func(d base.Datom) bool {
+		datoms = append(datoms, d)
+		return true
+	})
+	return e.value(s, attr, datoms)
+}
+
+// value is nil if there are no datoms
+func (e *LazyEntity) value(s schema.Interface, attr schema.Attr, datoms []base.Datom) any {
+	v, _ := AttrValue(s, attr, datoms, e.ref)
+	return v
+}
+
+// Touch reads every attribute of the entity, and of its components, at once
+func (e *LazyEntity) Touch() *LazyEntity {
+	return e.touch(make(map[int64]bool))
+}
+
+// touch doesn't go back to the entities in visited, components can form cycles
+func (e *LazyEntity) touch(visited map[int64]bool) *LazyEntity {
+	visited[e.id] = true
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.touched {
+		return e
+	}
+	e.touched = true
+
+	s := e.db.Schema()
+	var (
+		attr   schema.Attr
+		datoms []base.Datom
+	)
+	flush := func() {
+		if len(datoms) == 0 {
+			return
+		}
+		v := e.value(s, attr, datoms)
+		e.attrs[attr.Ident] = v
+		if attr.IsComponent {
+			vals, ok := v.([]any)
+			if !ok {
+				vals = []any{v}
+			}
+			for _, v := range vals {
+				if c := v.(*LazyEntity); !visited[c.id] {
+					c.touch(visited)
+				}
+			}
+		}
+	}
+	e.db.Datoms(base.EAVT, e.id)(func(d base.Datom) bool {
+		if d.A != attr.Id {
+			flush()
+			attr, datoms = schema.Attr{}, nil
+			if a, ok := s.Attr(d.A); ok {
+				attr = a
+			}
+		}
+		if attr.Id != 0 {
+			datoms = append(datoms, d)
+		}
+		return true
+	})
+	flush()
+	return e
+}
+
+// Keys are the attributes that the entity has, reverse attributes that have
+// been navigated are included
+func (e *LazyEntity) Keys() []symbol.Keyword {
+	e.Touch()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	keys := make([]symbol.Keyword, 0, len(e.attrs))
+	for a, v := range e.attrs {
+		if v != nil {
+			keys = append(keys, a)
+		}
+	}
+	slices.SortFunc(keys, func(a, b symbol.Keyword) int { return strings.Compare(a.String(), b.String()) })
+	return keys
+}
@@ -0,0 +1,127 @@
+package database_test
+
+import (
+	"testing"
+
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/symbol"
+	"github.com/leidegre/datoms/testutil"
+)
+
+func TestEntity(t *testing.T) {
+	var tx database.Transaction
+	db := database.Interface(database.NewTestDatabase())
+	for _, s := range []string{schemaEDN, fixtureEDN} {
+		txData, err := database.ReadTxData([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tx, err = db.With(txData); err != nil {
+			t.Fatal(err)
+		}
+		db = tx.DbAfter
+	}
+
+	var (
+		name    = symbol.For(":person/name")
+		age     = symbol.For(":person/age")
+		friends = symbol.For(":person/friends")
+	)
+
+	fred, err := database.Entity(db, base.Entity{Id: tx.TempNames["fred"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, ok := fred.Get(name)
+	testutil.AreEqual(t, true, ok)
+	testutil.AreEqual[any](t, "Fred", v)
+
+	v, _ = fred.Get(age)
+	testutil.AreEqual[any](t, int64(42), v)
+
+	_, ok = fred.Get(symbol.For(":person/unknown"))
+	testutil.AreEqual(t, false, ok)
+
+	v, _ = fred.Get(friends)
+	var names []any
+	for _, friend := range v.([]any) {
+		name, _ := friend.(*database.LazyEntity).Get(name)
+		names = append(names, name)
+	}
+	testutil.AreEqual(t, 2, len(names))
+
+	// ethel is friends with fred and fred is friends with ethel
+	v, _ = fred.Get(symbol.For(":person/_friends"))
+	testutil.AreEqual(t, 1, len(v.([]any)))
+	ethel := v.([]any)[0].(*database.LazyEntity)
+	testutil.AreEqual(t, tx.TempNames["ethel"], ethel.Id())
+	v, _ = ethel.Get(friends)
+	testutil.AreEqual(t, fred.Id(), v.([]any)[0].(*database.LazyEntity).Id())
+
+	// reverse attributes that have been navigated are keys as well
+	testutil.AreEqualSlice(t, []symbol.Keyword{symbol.For(":person/_friends"), age, friends, name}, fred.Touch().Keys())
+
+	ident, err := database.Entity(db, base.Entity{Ident: symbol.For(":person/name")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ = ident.Get(symbol.For(":db/ident"))
+	testutil.AreEqual[any](t, name, v)
+
+	if _, err := database.Entity(db, base.Entity{Ident: symbol.For(":person/unknown")}); err == nil {
+		t.Error("expected an error for an unknown ident")
+	}
+	if _, err := database.Entity(db, base.NewTempId(symbol.For(":db.part/user"))); err == nil {
+		t.Error("expected an error for a temp ID")
+	}
+}
+
+func TestEntityResolve(t *testing.T) {
+	const schemaEDN = `
+[{:db/id "email" :db/ident :part/email :db/valueType :db.type/string :db/cardinality :db.cardinality/one :db/unique :db.unique/identity}
+ {:db/id "child" :db/ident :part/child :db/valueType :db.type/ref :db/cardinality :db.cardinality/one :db/isComponent true}
+ {:db/id :db.part/db :db.install/attribute ["email" "child"]}]
+`
+	// the components form a cycle
+	const fixtureEDN = `
+[{:db/id "a" :part/email "a@example.com" :part/child "b"}
+ {:db/id "b" :part/email "b@example.com" :part/child "a"}]
+`
+
+	var tx database.Transaction
+	db := database.Interface(database.NewTestDatabase())
+	for _, s := range []string{schemaEDN, fixtureEDN} {
+		txData, err := database.ReadTxData([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tx, err = db.With(txData); err != nil {
+			t.Fatal(err)
+		}
+		db = tx.DbAfter
+	}
+
+	email := symbol.For(":part/email")
+
+	a, err := database.Entity(db, tx.TempNames["a"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ := a.Touch().Get(email)
+	testutil.AreEqual[any](t, "a@example.com", v)
+
+	b, err := database.Entity(db, []any{email, "b@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.AreEqual(t, tx.TempNames["b"], b.Id())
+
+	if _, err := database.Entity(db, []any{email, "c@example.com"}); err == nil {
+		t.Error("expected an error for a missing entity")
+	}
+	if _, err := database.Entity(db, []any{symbol.For(":part/child"), a.Id()}); err == nil {
+		t.Error("expected an error for a lookup ref of an attribute that isn't unique")
+	}
+}
@@ -22,7 +22,27 @@ func Map(id base.Entid, e base.EntityLike) base.TxData {
 	return base.TxMap{Id: id, Entity: e}
 }
 
-func Transact(db Interface, txData []base.TxData) (baseT int64, nextT int64, data []base.Datom, tempIds map[int64]int64, err error) {
+// Save is like Map but the struct is the desired state of the entity, the
+// values that the entity has but the struct doesn't are retracted. Nil
+// pointers and slices and empty strings are no value. Only the entity itself
+// is diffed, nested entities are asserted like Map.
+func Save(id base.Entid, e base.EntityLike) base.TxData {
+	return base.TxMap{Id: id, Entity: e, Diff: true}
+}
+
+// Excise permanently removes the datoms of an entity, or of an attribute
+// across all entities. If attrs are given only the datoms of those
+// attributes are removed. The excision is recorded in the transaction.
+func Excise(e base.Entid, attrs ...symbol.Keyword) base.TxData {
+	return base.TxExcise{E: e, Attrs: attrs}
+}
+
+// ExciseBefore is like Excise but it only removes datoms transacted before t
+func ExciseBefore(e base.Entid, t int64, attrs ...symbol.Keyword) base.TxData {
+	return base.TxExcise{E: e, Attrs: attrs, BeforeT: t}
+}
+
+func Transact(db Interface, txData []base.TxData) (baseT int64, nextT int64, data []base.Datom, tempIds map[int64]int64, tempNames map[string]int64, err error) {
 	var tx txBuilder
 
 	tx.init(db)
@@ -43,7 +63,17 @@ func Transact(db Interface, txData []base.TxData) (baseT int64, nextT int64, dat
 				return
 			}
 		case base.TxMap:
-			_, err = tx.txExpand(item.Id, item.Entity)
+			_, err = tx.txExpand(item.Id, item.Entity, item.Diff)
+			if err != nil {
+				return
+			}
+		case base.TxEntity:
+			_, err = tx.txEntity(item)
+			if err != nil {
+				return
+			}
+		case base.TxExcise:
+			err = tx.txExcise(item)
 			if err != nil {
 				return
 			}
@@ -52,32 +82,39 @@ func Transact(db Interface, txData []base.TxData) (baseT int64, nextT int64, dat
 		}
 	}
 
+	tx.txImplicit()
+
+	if err = tx.checkNamed(); err != nil {
+		return
+	}
+
+	resolveTempId := func(tempId int64) int64 {
+		newId, ok := tx.tempIds[tempId]
+		if !ok {
+			part, _ := pack.Unpack(tempId)
+			newId = tx.newId(part) // make a new entity
+			tx.tempIds[tempId] = newId
+		}
+		return newId
+	}
+
 	for i, d := range tx.data {
 		if d.E < 0 {
-			var (
-				newId int64
-				ok    bool
-			)
-			if newId, ok = tx.tempIds[d.E]; !ok {
-				part, _ := pack.Unpack(d.E)
-				newId = pack.EntityId(part, tx.nextT) // make a new entity
-				tx.tempIds[d.E] = newId
-				tx.nextT++
+			d.E = resolveTempId(d.E)
+		}
+		if v, ok := d.V.(int64); ok && v < 0 {
+			if attr, _ := tx.schema.Attr(d.A); attr.ValueType == tx.refType {
+				d.V = resolveTempId(v)
 			}
-			tx.data[i] = base.Datom{E: newId, A: d.A, V: d.V, T: d.T}
 		}
+		tx.data[i] = d
 	}
 
-	// ---
-
-	// We need to make a pass to detect missing implicit datoms
-
-	// We need to make a pass to eliminate redundant datoms
-
-	// For this we sort of need the b-tree...
-
-	// ---
+	tempNames = make(map[string]int64, len(tx.named))
+	for name, tempId := range tx.named {
+		tempNames[name] = resolveTempId(tempId)
+	}
 
-	baseT, nextT, data, tempIds = nextT, tx.nextT, tx.data, tx.tempIds
+	baseT, nextT, data, tempIds = tx.baseT, tx.nextT, tx.data, tx.tempIds
 	return
 }
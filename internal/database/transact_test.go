@@ -2,10 +2,14 @@ package database_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/leidegre/datoms/internal/base"
 	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/internal/pack"
 	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/symbol"
+	"github.com/leidegre/datoms/testutil"
 )
 
 func TestTransactAdd(t *testing.T) {
@@ -57,3 +61,357 @@ func TestTransact(t *testing.T) {
 
 	t.FailNow()
 }
+
+func TestTransactRenamedIdent(t *testing.T) {
+	db := database.NewTestDatabase()
+
+	var (
+		doc  = base.Entity{Ident: schema.DbDoc}
+		docs = symbol.For(":db/docs")
+	)
+
+	tx, err := db.With([]base.TxData{
+		database.Retract(doc, schema.DbIdent, schema.DbDoc),
+		database.Add(doc, schema.DbIdent, docs),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the old ident keeps resolving for transactions
+	tx, err = tx.DbAfter.With([]base.TxData{
+		database.Add(base.NewTempId(schema.DbPartUser), schema.DbDoc, "foo"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attr, _ := tx.DbAfter.Schema().AttrKeyword(docs)
+	testutil.AreEqual(t, attr.Id, tx.TxData[len(tx.TxData)-1].A)
+}
+
+func TestTransactPartition(t *testing.T) {
+	db := database.NewTestDatabase()
+
+	var (
+		foo   = symbol.For(":test.part/foo")
+		tPart = base.NewTempId(schema.DbPartDb)
+	)
+
+	tx, err := db.With([]base.TxData{
+		database.Add(tPart, schema.DbIdent, foo),
+		database.Add(base.Entity{Ident: schema.DbPartDb}, schema.DbInstallPartition, tPart),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	partId, ok := tx.DbAfter.Schema().Partition(foo)
+	if !ok {
+		t.Fatal("expected partition to be installed")
+	}
+
+	var (
+		t1 = base.NewTempId(foo)
+		t2 = base.NewTempId(schema.DbPartUser)
+		t3 = base.NewTempId(foo)
+	)
+
+	tx, err = tx.DbAfter.With([]base.TxData{
+		database.Add(t1, schema.DbDoc, "foo"),
+		database.Add(t2, schema.DbDoc, "bar"),
+		database.Add(t3, schema.DbDoc, "baz"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		e1 = tx.TxData[1].E
+		e2 = tx.TxData[2].E
+		e3 = tx.TxData[3].E
+	)
+
+	// entity IDs are allocated per partition
+	testutil.AreEqual(t, pack.EntityId(partId, database.FirstId), e1)
+	testutil.AreEqual(t, pack.EntityId(partId, database.FirstId+1), e3)
+
+	userPart, _ := tx.DbAfter.Schema().Partition(schema.DbPartUser)
+	testutil.AreEqual(t, pack.EntityId(userPart, database.FirstId), e2)
+	testutil.AreEqual(t, database.FirstId+2, tx.DbAfter.NextId(partId))
+
+	_, err = tx.DbAfter.With([]base.TxData{
+		database.Add(base.NewTempId(schema.DbDoc), schema.DbDoc, "qux"),
+	})
+	testutil.AreEqual(t, base.ErrCannotResolvePartition, err)
+
+	// the entity ID of a partition in :db.part/user is out of range
+	var (
+		bar   = symbol.For(":test.part/bar")
+		tUser = base.NewTempId(schema.DbPartUser)
+	)
+	tx, err = tx.DbAfter.With([]base.TxData{
+		database.Add(tUser, schema.DbIdent, bar),
+		database.Add(base.Entity{Ident: schema.DbPartDb}, schema.DbInstallPartition, tUser),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tx.DbAfter.With([]base.TxData{
+		database.Add(base.NewTempId(bar), schema.DbDoc, "qux"),
+	})
+	testutil.AreEqual(t, base.ErrCannotResolvePartition, err)
+}
+
+func TestTransactSave(t *testing.T) {
+	var tx database.Transaction
+	db := database.Interface(database.NewTestDatabase())
+	for _, s := range []string{schemaEDN, fixtureEDN} {
+		txData, err := database.ReadTxData([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tx, err = db.With(txData); err != nil {
+			t.Fatal(err)
+		}
+		db = tx.DbAfter
+	}
+	fredId, ethelId := tx.TempNames["fred"], tx.TempNames["ethel"]
+
+	type person struct {
+		base.Entity
+		Name    string  `ident:":person/name"`
+		Age     *int64  `ident:":person/age"`
+		Friends []int64 `ident:":person/friends"`
+	}
+
+	attr := func(ident string) int64 {
+		attr, _ := db.Schema().AttrKeyword(symbol.For(ident))
+		return attr.Id
+	}
+
+	// the name is changed, the age is cleared and lucy is no longer a friend
+	tx, err := db.With([]base.TxData{
+		database.Save(nil, person{Entity: base.Entity{Id: fredId}, Name: "Freddy", Friends: []int64{ethelId}}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var changes []string
+	for _, d := range tx.TxData {
+		if d.E == fredId {
+			changes = append(changes, d.String())
+		}
+	}
+	testutil.AreEqual(t, 4, len(changes))
+
+	values := func(a string) []any {
+		var vals []any
+		tx.DbAfter.Datoms(base.EAVT, fredId, attr(a))(func(d base.Datom) bool {
+			vals = append(vals, d.V)
+			return true
+		})
+		return vals
+	}
+	testutil.AreEqualSlice(t, []any{"Freddy"}, values(":person/name"))
+	testutil.AreEqual(t, 0, len(values(":person/age")))
+	testutil.AreEqualSlice(t, []any{ethelId}, values(":person/friends"))
+
+	// saving the same state again changes nothing
+	age := int64(43)
+	saved := person{Entity: base.Entity{Id: fredId}, Name: "Freddy", Age: &age, Friends: []int64{ethelId, ethelId}}
+	tx, err = tx.DbAfter.With([]base.TxData{database.Save(nil, saved)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err = tx.DbAfter.With([]base.TxData{database.Save(nil, saved)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range tx.TxData {
+		if d.E == fredId {
+			t.Errorf("unexpected %v", d)
+		}
+	}
+
+	// a nested entity with just an ID is a ref, ethel is left alone
+	type friend struct {
+		base.Entity
+		Name    string    `ident:":person/name"`
+		Friends []*friend `ident:":person/friends"`
+	}
+	tx, err = tx.DbAfter.With([]base.TxData{
+		database.Save(nil, friend{Entity: base.Entity{Id: fredId}, Name: "Fred", Friends: []*friend{{Entity: base.Entity{Id: ethelId}}}}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range tx.TxData {
+		if d.E == ethelId {
+			t.Errorf("unexpected %v", d)
+		}
+	}
+	testutil.AreEqualSlice(t, []any{"Fred"}, values(":person/name"))
+}
+
+func TestTransactNested(t *testing.T) {
+	db := database.Interface(database.NewTestDatabase())
+	for _, s := range []string{schemaEDN, `
+[{:db/id "tags" :db/ident :person/tags :db/valueType :db.type/string :db/cardinality :db.cardinality/many}
+ {:db/id "address" :db/ident :person/address :db/valueType :db.type/ref :db/cardinality :db.cardinality/one :db/isComponent true}
+ {:db/id "city" :db/ident :address/city :db/valueType :db.type/string :db/cardinality :db.cardinality/one}
+ {:db/id :db.part/db :db.install/attribute ["tags" "address" "city"]}]
+`} {
+		txData, err := database.ReadTxData([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx, err := db.With(txData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db = tx.DbAfter
+	}
+
+	type address struct {
+		base.Entity
+		City string `ident:":address/city"`
+	}
+
+	type person struct {
+		base.Entity
+		Name    string    `ident:":person/name"`
+		Tags    []string  `ident:":person/tags"`
+		Address *address  `ident:":person/address"`
+		Friends []*person `ident:":person/friends"` // recursive type
+	}
+
+	fred := &person{Name: "Fred", Tags: []string{"a", "b"}, Address: &address{City: "Lund"}}
+	ethel := &person{Name: "Ethel", Friends: []*person{fred}}
+	fred.Friends = []*person{ethel} // and a cycle
+
+	tx, err := db.With([]base.TxData{database.Map(nil, fred)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		name    = symbol.For(":person/name")
+		friends = symbol.For(":person/friends")
+	)
+
+	var fredId int64
+	tx.DbAfter.Datoms(base.AVET, attrId(t, tx.DbAfter, ":person/name"), "Fred")(func(d base.Datom) bool {
+		fredId = d.E
+		return false
+	})
+	e, err := database.Entity(tx.DbAfter, base.Entity{Id: fredId})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, _ := e.Get(symbol.For(":person/tags"))
+	testutil.AreEqualSlice(t, []any{"a", "b"}, v.([]any))
+
+	v, _ = e.Get(symbol.For(":person/address"))
+	v, _ = v.(*database.LazyEntity).Get(symbol.For(":address/city"))
+	testutil.AreEqual[any](t, "Lund", v)
+
+	v, _ = e.Get(friends)
+	ethelEntity := v.([]any)[0].(*database.LazyEntity)
+	v, _ = ethelEntity.Get(name)
+	testutil.AreEqual[any](t, "Ethel", v)
+	v, _ = ethelEntity.Get(friends)
+	testutil.AreEqual(t, fredId, v.([]any)[0].(*database.LazyEntity).Id())
+
+	type invalid struct {
+		base.Entity
+		Name *address `ident:":person/name"`
+	}
+	if _, err := db.With([]base.TxData{database.Map(nil, invalid{Name: &address{City: "Lund"}})}); err == nil {
+		t.Error("expected an error for an entity value of a string attribute")
+	}
+}
+
+func attrId(t *testing.T, db database.Interface, ident string) int64 {
+	attr, ok := db.Schema().AttrKeyword(symbol.For(ident))
+	if !ok {
+		t.Fatalf("no attribute %v", ident)
+	}
+	return attr.Id
+}
+
+func TestNoHistory(t *testing.T) {
+	s := schema.New()
+
+	id := func(ident symbol.Keyword) int64 {
+		id, _ := s.Id(ident)
+		return id
+	}
+
+	var (
+		seen = symbol.For(":test/seen")
+		attr = pack.EntityId(id(schema.DbPartDb), 100)
+		e    = pack.EntityId(id(schema.DbPartUser), 1)
+		t1   = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		t2   = t1.Add(time.Hour)
+	)
+
+	s = s.With([]base.Datom{
+		base.NewDatom(attr, id(schema.DbIdent), seen, 1, 1),
+		base.NewDatom(attr, id(schema.DbValueType), id(schema.DbTypeInstant), 1, 1),
+		base.NewDatom(attr, id(schema.DbCardinality), id(schema.DbCardinalityOne), 1, 1),
+		base.NewDatom(attr, id(schema.DbNoHistory), true, 1, 1),
+		base.NewDatom(id(schema.DbPartDb), id(schema.DbInstallAttribute), attr, 1, 1),
+	})
+
+	data, drop := database.NoHistory(s, []base.Datom{
+		base.NewDatom(e, attr, t1, 2, 0),
+		base.NewDatom(e, attr, t2, 2, 1),
+	})
+
+	testutil.AreEqual(t, 1, len(data))
+	// instants are the same value in any location
+	testutil.AreEqual(t, true, drop(base.NewDatom(e, attr, t1.In(time.FixedZone("CET", 3600)), 1, 1)))
+	testutil.AreEqual(t, false, drop(base.NewDatom(e, attr, t2, 1, 1)))
+}
+
+func TestTransactFromStructs(t *testing.T) {
+	type item struct {
+		base.Entity
+		Name   string  `ident:":item/name"`
+		Count  int     `ident:":item/count"`
+		Small  int8    `ident:":item/small"`
+		Size   uint16  `ident:":item/size"`
+		Weight float32 `ident:":item/weight"`
+	}
+
+	txData, err := schema.FromStructs(item{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := database.Interface(database.NewTestDatabase())
+	tx, err := db.With(txData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = tx.DbAfter.With([]base.TxData{
+		database.Map(nil, item{Name: "a", Count: 1, Small: -2, Size: 3, Weight: 0.5}),
+		database.Map(nil, item{Name: "b", Count: 2, Small: -3, Size: 4, Weight: 1.5}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db = tx.DbAfter
+
+	var vals []any
+	for _, ident := range []string{":item/count", ":item/small", ":item/size", ":item/weight"} {
+		db.Datoms(base.AEVT, attrId(t, db, ident))(func(d base.Datom) bool {
+			vals = append(vals, d.V)
+			return true
+		})
+	}
+	testutil.AreEqualSlice(t, []any{int64(1), int64(2), int64(-2), int64(-3), int64(3), int64(4), 0.5, 1.5}, vals)
+}
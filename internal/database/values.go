@@ -0,0 +1,68 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/symbol"
+)
+
+// ReverseAttr is the attribute of a reverse attribute like :person/_friend,
+// ok is false if a isn't a reverse attribute
+func ReverseAttr(a symbol.Keyword) (attr symbol.Keyword, ok bool) {
+	ns, name, ok := strings.Cut(a.String(), "/")
+	if !(ok && strings.HasPrefix(name, "_")) {
+		return symbol.Keyword{}, false
+	}
+	return symbol.For(ns + "/" + name[1:]), true
+}
+
+// AttrValue is the value of an attribute of an entity from its datoms, a
+// slice if the attribute is cardinality many. ref makes the values of ref
+// attributes. It's nil if there are no datoms.
+func AttrValue(s schema.Interface, attr schema.Attr, datoms []base.Datom, ref func(e int64) (any, error)) (any, error) {
+	if len(datoms) == 0 {
+		return nil, nil
+	}
+	var (
+		refType, _  = s.Id(schema.DbTypeRef)
+		cardMany, _ = s.Id(schema.DbCardinalityMany)
+		vals        = make([]any, 0, len(datoms))
+	)
+	for _, d := range datoms {
+		v := d.V
+		if attr.ValueType == refType {
+			var err error
+			if v, err = ref(d.V.(int64)); err != nil {
+				return nil, err
+			}
+		}
+		vals = append(vals, v)
+	}
+	if attr.Cardinality == cardMany {
+		return vals, nil
+	}
+	return vals[0], nil
+}
+
+// ReverseValue is the value of a reverse attribute from the datoms of the VAET
+// index that refer to an entity, the referring entities or the one owner of
+// a component. ref makes the values. It's nil if there are no datoms.
+func ReverseValue(attr schema.Attr, datoms []base.Datom, ref func(e int64) (any, error)) (any, error) {
+	if len(datoms) == 0 {
+		return nil, nil
+	}
+	refs := make([]any, 0, len(datoms))
+	for _, d := range datoms {
+		v, err := ref(d.E)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, v)
+	}
+	if attr.IsComponent {
+		return refs[0], nil // a component has a single owner
+	}
+	return refs, nil
+}
@@ -55,3 +55,22 @@ func TestLive(t *testing.T) {
 	testutil.AreEqual(t, datom(0, 1, "baz", 2, true), live[0])
 	testutil.AreEqual(t, datom(1, 1, "qux", 3, true), live[1])
 }
+
+func TestLiveStop(t *testing.T) {
+	hist := []base.Datom{
+		datom(0, 1, "foo", 0, true),
+		datom(1, 1, "bar", 0, true),
+		datom(2, 1, "baz", 0, true),
+	}
+
+	live := iterutil.Live(iter.Forward(hist))
+
+	for i := 0; i < 2; i++ {
+		n := 0
+		live(func(d base.Datom) bool {
+			n++
+			return false
+		})
+		testutil.AreEqual(t, 1, n)
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/leidegre/datoms/internal/migrate
+
+go 1.21
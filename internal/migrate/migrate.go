@@ -0,0 +1,207 @@
+// Package migrate applies named migrations to a database exactly once
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/symbol"
+)
+
+// Name is the attribute of the marker entities of the migrations that have
+// been applied
+var Name = symbol.For(":datoms.migration/name")
+
+// Migration is transaction data that is transacted once, the migration is
+// identified by its name
+type Migration struct {
+	Name   string
+	TxData []base.TxData
+}
+
+// Ensure applies the migrations that haven't been applied, in order, and
+// checks that the installed schema conforms to the attributes that the
+// migrations declare. A migration and its marker are one transaction, the
+// check for the marker is made within that transaction so that any number
+// of callers that share the connection can ensure the same migrations at
+// once. Callers with separate connections to the same database are not
+// serialized and can apply a migration twice.
+//
+// The names of the migrations that were applied are returned, also when
+// there's an error: a migration that fails or a schema that doesn't conform
+// leaves the migrations before it applied.
+func Ensure(conn *database.Connection, migrations ...Migration) (applied []string, err error) {
+	if _, err = conn.TransactFunc(installName); err != nil {
+		return nil, err
+	}
+
+	for _, m := range migrations {
+		if m.Name == "" {
+			return applied, errors.New("datoms: a migration must have a name")
+		}
+		ran := false
+		_, err = conn.TransactFunc(func(db database.Interface) ([]base.TxData, error) {
+			if Applied(db, m.Name) {
+				return nil, nil
+			}
+			ran = true
+			marker := base.TxAdd{E: base.NewTempId(schema.DbPartUser), A: Name, V: m.Name}
+			return append(slices.Clip(m.TxData), marker), nil
+		})
+		if err != nil {
+			return applied, fmt.Errorf("datoms: migration %v: %w", m.Name, err)
+		}
+		if ran {
+			applied = append(applied, m.Name)
+		}
+	}
+
+	return applied, Conforms(conn.Db().Schema(), migrations...)
+}
+
+// installName installs the marker attribute unless it's installed already
+func installName(db database.Interface) ([]base.TxData, error) {
+	if _, ok := db.Schema().AttrKeyword(Name); ok {
+		return nil, nil
+	}
+	attr := base.NewTempId(schema.DbPartDb)
+	return []base.TxData{
+		database.Add(attr, schema.DbIdent, Name),
+		database.Add(attr, schema.DbValueType, schema.DbTypeString),
+		database.Add(attr, schema.DbCardinality, schema.DbCardinalityOne),
+		database.Add(attr, schema.DbUnique, schema.DbUniqueValue),
+		database.Add(base.Entity{Ident: schema.DbPartDb}, schema.DbInstallAttribute, attr),
+	}, nil
+}
+
+// Applied reports whether the marker of the migration is in the database
+func Applied(db database.Interface, name string) bool {
+	attr, ok := db.Schema().AttrKeyword(Name)
+	if !ok {
+		return false
+	}
+	applied := false
+	db.Datoms(base.AVET, attr.Id, name)(func(base.Datom) bool {
+		applied = true
+		return false
+	})
+	return applied
+}
+
+// declared is an attribute as declared by transaction data
+type declared map[symbol.Keyword]any
+
+var attrProps = []symbol.Keyword{
+	schema.DbValueType,
+	schema.DbCardinality,
+	schema.DbUnique,
+	schema.DbIsComponent,
+	schema.DbNoHistory,
+	schema.DbIndex,
+}
+
+// Conforms checks that the installed schema has the attributes that the
+// migrations declare, with the same value type, cardinality, uniqueness
+// and options. An attribute is declared by an entity with :db/ident and
+// :db/valueType.
+func Conforms(s schema.Interface, migrations ...Migration) error {
+	var errs []error
+	for _, m := range migrations {
+		for _, attr := range declarations(m.TxData) {
+			ident, _ := attr[schema.DbIdent].(symbol.Keyword)
+			if err := conforms(s, ident, attr); err != nil {
+				errs = append(errs, fmt.Errorf("datoms: migration %v: %w", m.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// declarations are the attributes that transaction data declares, in order
+func declarations(txData []base.TxData) []declared {
+	var (
+		entities []declared
+		byEntid  = make(map[base.Entid]declared)
+	)
+	for _, item := range txData {
+		switch item := item.(type) {
+		case base.TxAdd:
+			if item.E == nil || !reflect.TypeOf(item.E).Comparable() {
+				continue // not an attribute
+			}
+			e, ok := byEntid[item.E]
+			if !ok {
+				e = make(declared)
+				byEntid[item.E] = e
+				entities = append(entities, e)
+			}
+			e[item.A] = item.V
+		case base.TxEntity:
+			entities = append(entities, declared(item))
+		}
+	}
+	var attrs []declared
+	for _, e := range entities {
+		if _, ok := e[schema.DbIdent].(symbol.Keyword); ok && e[schema.DbValueType] != nil {
+			attrs = append(attrs, e)
+		}
+	}
+	return attrs
+}
+
+func conforms(s schema.Interface, ident symbol.Keyword, decl declared) error {
+	attr, ok := s.AttrKeyword(ident)
+	if !ok {
+		return fmt.Errorf("%v is not installed", ident)
+	}
+	installed := map[symbol.Keyword]any{
+		schema.DbValueType:   attr.ValueType,
+		schema.DbCardinality: attr.Cardinality,
+		schema.DbUnique:      attr.Unique,
+		schema.DbIsComponent: attr.IsComponent,
+		schema.DbNoHistory:   attr.NoHistory,
+		schema.DbIndex:       attr.Index,
+	}
+	var errs []error
+	for _, prop := range attrProps {
+		want, ok := decl[prop]
+		if !ok {
+			continue
+		}
+		have := installed[prop]
+		if id, ok := have.(int64); ok {
+			if want = resolve(s, want); want != id {
+				var name any = "none"
+				if ident, ok := s.Ident(id); ok {
+					name = ident
+				}
+				errs = append(errs, fmt.Errorf("%v %v is %v, not %v", ident, prop, name, decl[prop]))
+			}
+		} else if want != have {
+			errs = append(errs, fmt.Errorf("%v %v is %v, not %v", ident, prop, have, want))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// resolve is the entity ID of a ref value
+func resolve(s schema.Interface, v any) any {
+	switch v := v.(type) {
+	case symbol.Keyword:
+		if id, ok := s.Id(v); ok {
+			return id
+		}
+	case base.EntityLike:
+		id, ident := base.EntityIdentities(v)
+		if id == 0 {
+			id, _ = s.Id(ident)
+		}
+		return id
+	}
+	return v
+}
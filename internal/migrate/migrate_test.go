@@ -0,0 +1,115 @@
+package migrate_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/internal/migrate"
+	"github.com/leidegre/datoms/storage/mem"
+	"github.com/leidegre/datoms/symbol"
+	"github.com/leidegre/datoms/testutil"
+)
+
+func migration(t *testing.T, name, s string) migrate.Migration {
+	txData, err := database.ReadTxData([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return migrate.Migration{Name: name, TxData: txData}
+}
+
+func migrations(t *testing.T) []migrate.Migration {
+	return []migrate.Migration{
+		migration(t, "person", `
+[{:db/id "name" :db/ident :person/name :db/valueType :db.type/string :db/cardinality :db.cardinality/one
+  :db/unique :db.unique/identity}
+ {:db/id "friends" :db/ident :person/friends :db/valueType :db.type/ref :db/cardinality :db.cardinality/many}
+ {:db/id :db.part/db :db.install/attribute ["name" "friends"]}]`),
+		migration(t, "fred", `[{:person/name "Fred"}]`),
+	}
+}
+
+func TestEnsure(t *testing.T) {
+	conn := database.Connect(mem.New())
+
+	applied, err := migrate.Ensure(conn, migrations(t)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.AreEqualSlice(t, []string{"person", "fred"}, applied)
+
+	db := conn.Db()
+	testutil.AreEqual(t, true, migrate.Applied(db, "fred"))
+
+	applied, err = migrate.Ensure(conn, migrations(t)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.AreEqual(t, 0, len(applied))
+	testutil.AreEqual(t, db, conn.Db()) // nothing is transacted
+}
+
+func TestEnsureConcurrently(t *testing.T) {
+	conn := database.Connect(mem.New())
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		applied []string
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tmp, err := migrate.Ensure(conn, migrations(t)...)
+			if err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			applied = append(applied, tmp...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	testutil.AreEqual(t, 2, len(applied))
+
+	db := conn.Db()
+	attr, _ := db.Schema().AttrKeyword(symbol.For(":person/name"))
+	n := 0
+	db.Datoms(base.AEVT, attr.Id)(func(base.Datom) bool {
+		n++
+		return true
+	})
+	testutil.AreEqual(t, 1, n)
+}
+
+func TestConforms(t *testing.T) {
+	conn := database.Connect(mem.New())
+
+	if _, err := migrate.Ensure(conn, migrations(t)[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same migration, declared differently
+	_, err := migrate.Ensure(conn, migration(t, "person", `
+[{:db/id "name" :db/ident :person/name :db/valueType :db.type/long :db/cardinality :db.cardinality/one}
+ {:db/id "friends" :db/ident :person/friends :db/valueType :db.type/ref :db/cardinality :db.cardinality/one}
+ {:db/id "age" :db/ident :person/age :db/valueType :db.type/long :db/cardinality :db.cardinality/one}
+ {:db/id :db.part/db :db.install/attribute ["name" "friends" "age"]}]`))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, s := range []string{
+		":person/name :db/valueType is :db.type/string, not :db.type/long",
+		":person/friends :db/cardinality is :db.cardinality/many, not :db.cardinality/one",
+		":person/age is not installed",
+	} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("expected %q in %v", s, err)
+		}
+	}
+}
@@ -7,13 +7,19 @@ const (
 	tempId       = -4611686018427387904 // 0xc000000000000000
 )
 
+// IsPartition reports whether the entity ID of a partition fits the
+// partition bits of an entity ID
+func IsPartition(part int64) bool {
+	return 1 <= part && part <= maxPartition+1
+}
+
 func EntityId(part, ent int64) int64 {
 	// Assuming each entity is just 1 datom of 25 bytes each
 	// the footprint of a full database is at least ~100 TiB
 
 	// s t pppppppppppppppppppp eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee
 
-	if !(1 <= part && part <= maxPartition+1) {
+	if !IsPartition(part) {
 		panic("datoms: partition ID is out of range")
 	}
 
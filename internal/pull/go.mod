@@ -0,0 +1,3 @@
+module github.com/leidegre/datoms/internal/pull
+
+go 1.21
@@ -0,0 +1,351 @@
+// Pull patterns select a tree of attributes of an entity
+package pull
+
+import (
+	"fmt"
+
+	"github.com/leidegre/datoms/edn"
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/symbol"
+)
+
+// DefaultLimit is the number of values of a cardinality many or reverse
+// attribute that are pulled unless a limit is given
+const DefaultLimit = 1000
+
+var (
+	optLimit   = symbol.For(":limit")
+	optDefault = symbol.For(":default")
+	optAs      = symbol.For(":as")
+)
+
+// Pattern is a compiled pull pattern
+type Pattern struct {
+	Wildcard bool // * pulls every attribute and the components of the entity
+	Attrs    []Attr
+}
+
+// Attr is an attribute of a pull pattern
+type Attr struct {
+	Ident   symbol.Keyword // the attribute, like :person/friend or :person/_friend
+	Reverse bool           // Ident is a reverse attribute
+	Key     symbol.Keyword // the key of the result, Ident unless renamed with :as
+	Limit   int            // -1 is no limit
+	Default any            // the value if the entity has no value, nil is none
+	Pattern *Pattern       // the pattern of the entities that a ref attribute refers to
+	Depth   int            // recursion depth, -1 is unbounded, 0 is no recursion
+}
+
+// Parse compiles a pull pattern like
+//
+//	[:person/name {:person/friend [:person/name]}]
+//	[* (:person/friend :limit 10) [:person/nick :default "none"]]
+//	[:person/name :person/_friend {:person/friend 3}]
+//	[:person/name {:person/friend ...}]
+//
+// The pattern is data, as read from EDN or written as Go literals where
+// * and ... are edn.Symbol or strings.
+func Parse(v any) (*Pattern, error) {
+	elems, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("datoms: pull pattern is a vector, not %T", v)
+	}
+	p := &Pattern{}
+	for _, elem := range elems {
+		switch elem := elem.(type) {
+		case map[any]any:
+			for k, v := range elem {
+				attr, err := parseAttr(k)
+				if err != nil {
+					return nil, err
+				}
+				switch v := v.(type) {
+				case []any:
+					if attr.Pattern, err = Parse(v); err != nil {
+						return nil, err
+					}
+				case int64:
+					if v < 1 {
+						return nil, fmt.Errorf("datoms: pull recursion limit %v is not positive", v)
+					}
+					attr.Depth = int(v)
+				case int:
+					if v < 1 {
+						return nil, fmt.Errorf("datoms: pull recursion limit %v is not positive", v)
+					}
+					attr.Depth = v
+				default:
+					if !isSymbol(v, "...") {
+						return nil, fmt.Errorf("datoms: invalid pull pattern %v for %v", v, k)
+					}
+					attr.Depth = -1
+				}
+				p.Attrs = append(p.Attrs, attr)
+			}
+		default:
+			if isSymbol(elem, "*") {
+				p.Wildcard = true
+				continue
+			}
+			attr, err := parseAttr(elem)
+			if err != nil {
+				return nil, err
+			}
+			p.Attrs = append(p.Attrs, attr)
+		}
+	}
+	return p, nil
+}
+
+func isSymbol(v any, name string) bool {
+	switch v := v.(type) {
+	case edn.Symbol:
+		return string(v) == name
+	case string:
+		return v == name
+	}
+	return false
+}
+
+// parseAttr parses :person/name, (:person/name :limit 10) or [:person/name :as :name]
+func parseAttr(v any) (Attr, error) {
+	var (
+		ident symbol.Keyword
+		opts  []any
+	)
+	switch v := v.(type) {
+	case symbol.Keyword:
+		ident = v
+	case edn.List:
+		opts = v
+	case []any:
+		opts = v
+	default:
+		return Attr{}, fmt.Errorf("datoms: invalid pull attribute %v", v)
+	}
+	if opts != nil {
+		kw, ok := opts[0].(symbol.Keyword)
+		if len(opts)%2 != 1 || !ok {
+			return Attr{}, fmt.Errorf("datoms: invalid pull attribute %v", v)
+		}
+		ident, opts = kw, opts[1:]
+	}
+
+	attr := Attr{Ident: ident, Key: ident, Limit: DefaultLimit}
+	if forward, ok := database.ReverseAttr(ident); ok {
+		attr.Reverse = true
+		attr.Ident = forward
+	}
+
+	for i := 0; i < len(opts); i += 2 {
+		switch opts[i] {
+		case optLimit:
+			switch n := opts[i+1].(type) {
+			case nil:
+				attr.Limit = -1
+			case int64:
+				attr.Limit = int(n)
+			case int:
+				attr.Limit = n
+			default:
+				return Attr{}, fmt.Errorf("datoms: pull limit %v is not a number", n)
+			}
+		case optDefault:
+			attr.Default = opts[i+1]
+		case optAs:
+			key, ok := opts[i+1].(symbol.Keyword)
+			if !ok {
+				return Attr{}, fmt.Errorf("datoms: pull :as %v is not a keyword", opts[i+1])
+			}
+			attr.Key = key
+		default:
+			return Attr{}, fmt.Errorf("datoms: unknown pull option %v", opts[i])
+		}
+	}
+	return attr, nil
+}
+
+// Pull pulls the attributes of an entity, e is an entity ID, an ident, an
+// entity like base.Entity or a lookup ref. The entities that ref attributes refer to are maps with at least :db/id.
+func Pull(db database.Interface, pattern any, e any) (map[symbol.Keyword]any, error) {
+	p, err := Parse(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return p.Pull(db, e)
+}
+
+// PullMany is like Pull for several entities
+func PullMany(db database.Interface, pattern any, es []any) ([]map[symbol.Keyword]any, error) {
+	p, err := Parse(pattern)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]map[symbol.Keyword]any, len(es))
+	for i, e := range es {
+		if result[i], err = p.Pull(db, e); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// Pull pulls the attributes of an entity, e is resolved like
+// database.ResolveEntid
+func (p *Pattern) Pull(db database.Interface, e any) (map[symbol.Keyword]any, error) {
+	s := db.Schema()
+	id, err := database.ResolveEntid(db, e)
+	if err != nil {
+		return nil, fmt.Errorf("datoms: cannot resolve entity %v: %w", e, err)
+	}
+
+	pl := &puller{db: db, s: s, depth: make(map[*Attr]int), path: make(map[int64]bool)}
+	pl.refType, _ = s.Id(schema.DbTypeRef)
+	return pl.pull(p, id)
+}
+
+type puller struct {
+	db      database.Interface
+	s       schema.Interface
+	refType int64
+	depth   map[*Attr]int  // recursions so far
+	path    map[int64]bool // entities that are being pulled, for cycles
+}
+
+func (pl *puller) pull(p *Pattern, e int64) (map[symbol.Keyword]any, error) {
+	pl.path[e] = true
+	defer delete(pl.path, e)
+
+	result := make(map[symbol.Keyword]any)
+
+	if p.Wildcard {
+		result[schema.DbId] = e
+		var (
+			a    int64
+			vals []base.Datom
+		)
+		flush := func() error {
+			if len(vals) == 0 {
+				return nil
+			}
+			attr, ok := pl.s.Attr(a)
+			if !ok {
+				return nil
+			}
+			v, err := pl.values(attr, vals, nil)
+			if err != nil {
+				return err
+			}
+			result[attr.Ident] = v
+			return nil
+		}
+		var err error
+		pl.db.Datoms(base.EAVT, e)(func(d base.Datom) bool {
+			if d.A != a {
+				if err = flush(); err != nil {
+					return false
+				}
+				a, vals = d.A, nil
+			}
+			vals = append(vals, d)
+			return true
+		})
+		if err == nil {
+			err = flush()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range p.Attrs {
+		attr := &p.Attrs[i]
+
+		if attr.Ident == schema.DbId && !attr.Reverse {
+			result[attr.Key] = e
+			continue
+		}
+
+		sa, ok := pl.s.AttrKeyword(attr.Ident)
+		if !ok {
+			return nil, fmt.Errorf("datoms: unknown attribute %v", attr.Ident)
+		}
+		if p.Wildcard && attr.Key != attr.Ident && !attr.Reverse {
+			delete(result, attr.Ident) // renamed
+		}
+
+		var datoms []base.Datom
+		if attr.Reverse {
+			if sa.ValueType != pl.refType {
+				return nil, fmt.Errorf("datoms: %v is not a ref attribute", attr.Ident)
+			}
+			pl.db.Datoms(base.VAET, e, sa.Id)(func(d base.Datom) bool {
+				datoms = append(datoms, d)
+				return attr.Limit < 0 || len(datoms) < attr.Limit
+			})
+		} else {
+			pl.db.Datoms(base.EAVT, e, sa.Id)(func(d base.Datom) bool {
+				datoms = append(datoms, d)
+				return attr.Limit < 0 || len(datoms) < attr.Limit
+			})
+		}
+
+		if len(datoms) == 0 {
+			if attr.Default != nil {
+				result[attr.Key] = attr.Default
+			}
+			continue
+		}
+
+		v, err := pl.attr(p, attr, sa, datoms)
+		if err != nil {
+			return nil, err
+		}
+		result[attr.Key] = v
+	}
+
+	return result, nil
+}
+
+// attr is the value of an attribute in the pattern
+func (pl *puller) attr(p *Pattern, attr *Attr, sa schema.Attr, datoms []base.Datom) (any, error) {
+	ref := func(e int64) (any, error) { return pl.ref(p, attr, e) }
+	if attr.Reverse {
+		return database.ReverseValue(sa, datoms, ref)
+	}
+	return pl.values(sa, datoms, ref)
+}
+
+// values is the value of an attribute, a slice if it's cardinality many. ref
+// pulls the entities that a ref attribute refers to, if it's nil components
+// are pulled with a wildcard and other entities are just their :db/id.
+func (pl *puller) values(sa schema.Attr, datoms []base.Datom, ref func(e int64) (any, error)) (any, error) {
+	if ref == nil {
+		ref = func(e int64) (any, error) {
+			if sa.IsComponent && !pl.path[e] {
+				return pl.pull(&Pattern{Wildcard: true}, e)
+			}
+			return map[symbol.Keyword]any{schema.DbId: e}, nil
+		}
+	}
+	return database.AttrValue(pl.s, sa, datoms, ref)
+}
+
+// ref pulls an entity that attr refers to
+func (pl *puller) ref(p *Pattern, attr *Attr, e int64) (any, error) {
+	switch {
+	case attr.Pattern != nil:
+		return pl.pull(attr.Pattern, e)
+	case attr.Depth != 0:
+		if pl.path[e] || (0 < attr.Depth && attr.Depth <= pl.depth[attr]) {
+			return map[symbol.Keyword]any{schema.DbId: e}, nil
+		}
+		pl.depth[attr]++
+		defer func() { pl.depth[attr]-- }()
+		return pl.pull(p, e)
+	default:
+		return map[symbol.Keyword]any{schema.DbId: e}, nil
+	}
+}
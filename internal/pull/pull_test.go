@@ -0,0 +1,175 @@
+package pull_test
+
+import (
+	"testing"
+
+	"github.com/leidegre/datoms/edn"
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/internal/pull"
+	"github.com/leidegre/datoms/storage/mem"
+	"github.com/leidegre/datoms/symbol"
+	"github.com/leidegre/datoms/testutil"
+)
+
+const schemaEDN = `
+[{:db/id "name" :db/ident :person/name :db/valueType :db.type/string :db/cardinality :db.cardinality/one}
+ {:db/id "nick" :db/ident :person/nick :db/valueType :db.type/string :db/cardinality :db.cardinality/one}
+ {:db/id "friend" :db/ident :person/friend :db/valueType :db.type/ref :db/cardinality :db.cardinality/many}
+ {:db/id "manager" :db/ident :person/manager :db/valueType :db.type/ref :db/cardinality :db.cardinality/one}
+ {:db/id "address" :db/ident :person/address :db/valueType :db.type/ref :db/cardinality :db.cardinality/one :db/isComponent true}
+ {:db/id "city" :db/ident :address/city :db/valueType :db.type/string :db/cardinality :db.cardinality/one}
+ {:db/id :db.part/db :db.install/attribute ["name" "nick" "friend" "manager" "address" "city"]}]
+`
+
+const fixtureEDN = `
+[{:db/id "alice" :person/name "Alice" :person/address {:db/id "home" :address/city "Lund"} :person/friend ["bob" "carol"]}
+ {:db/id "bob" :person/name "Bob" :person/nick "Bobby" :person/manager "alice" :person/friend ["alice"]}
+ {:db/id "carol" :person/name "Carol" :person/manager "bob"}
+ {:db/id "dave" :person/name "Dave" :person/manager "carol"}]
+`
+
+func kw(s string) symbol.Keyword { return symbol.For(s) }
+
+func setup(t *testing.T) (database.Interface, map[string]int64) {
+	db := database.Interface(mem.New())
+	var tx database.Transaction
+	for _, s := range []string{schemaEDN, fixtureEDN} {
+		txData, err := database.ReadTxData([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tx, err = db.With(txData); err != nil {
+			t.Fatal(err)
+		}
+		db = tx.DbAfter
+	}
+	return db, tx.TempNames
+}
+
+func pattern(t *testing.T, s string) any {
+	v, err := edn.ReadString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func str(t *testing.T, v any) string {
+	s, err := edn.Print(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestPull(t *testing.T) {
+	db, ids := setup(t)
+
+	alice, bob, carol, dave := ids["alice"], ids["bob"], ids["carol"], ids["dave"]
+
+	for _, test := range []struct {
+		pattern string
+		e       int64
+		expect  map[symbol.Keyword]any
+	}{
+		{`[:person/name :person/nick]`, alice, map[symbol.Keyword]any{
+			kw(":person/name"): "Alice",
+		}},
+		{`[:db/id :person/manager]`, carol, map[symbol.Keyword]any{
+			kw(":db/id"):          carol,
+			kw(":person/manager"): map[symbol.Keyword]any{kw(":db/id"): bob},
+		}},
+		{`[{:person/manager [:person/name]}]`, carol, map[symbol.Keyword]any{
+			kw(":person/manager"): map[symbol.Keyword]any{kw(":person/name"): "Bob"},
+		}},
+		{`[:person/name {:person/_manager [:person/name]}]`, bob, map[symbol.Keyword]any{
+			kw(":person/name"):     "Bob",
+			kw(":person/_manager"): []any{map[symbol.Keyword]any{kw(":person/name"): "Carol"}},
+		}},
+		{`[(:person/nick :default "none") [:person/name :as :name]]`, alice, map[symbol.Keyword]any{
+			kw(":person/nick"): "none",
+			kw(":name"):        "Alice",
+		}},
+		{`[(:person/friend :limit 1)]`, alice, map[symbol.Keyword]any{
+			kw(":person/friend"): []any{map[symbol.Keyword]any{kw(":db/id"): bob}},
+		}},
+		{`[*]`, alice, map[symbol.Keyword]any{
+			kw(":db/id"):       alice,
+			kw(":person/name"): "Alice",
+			kw(":person/address"): map[symbol.Keyword]any{
+				kw(":db/id"):        ids["home"],
+				kw(":address/city"): "Lund",
+			},
+			kw(":person/friend"): []any{
+				map[symbol.Keyword]any{kw(":db/id"): bob},
+				map[symbol.Keyword]any{kw(":db/id"): carol},
+			},
+		}},
+		{`[:person/name {:person/manager ...}]`, dave, map[symbol.Keyword]any{
+			kw(":person/name"): "Dave",
+			kw(":person/manager"): map[symbol.Keyword]any{
+				kw(":person/name"): "Carol",
+				kw(":person/manager"): map[symbol.Keyword]any{
+					kw(":person/name"): "Bob",
+					kw(":person/manager"): map[symbol.Keyword]any{
+						kw(":person/name"): "Alice",
+					},
+				},
+			},
+		}},
+		{`[:person/name {:person/manager 1}]`, dave, map[symbol.Keyword]any{
+			kw(":person/name"): "Dave",
+			kw(":person/manager"): map[symbol.Keyword]any{
+				kw(":person/name"):    "Carol",
+				kw(":person/manager"): map[symbol.Keyword]any{kw(":db/id"): bob},
+			},
+		}},
+		{`[:person/name {:person/friend ...}]`, alice, map[symbol.Keyword]any{
+			kw(":person/name"): "Alice",
+			kw(":person/friend"): []any{
+				map[symbol.Keyword]any{
+					kw(":person/name"):   "Bob",
+					kw(":person/friend"): []any{map[symbol.Keyword]any{kw(":db/id"): alice}}, // cycle
+				},
+				map[symbol.Keyword]any{kw(":person/name"): "Carol"},
+			},
+		}},
+	} {
+		actual, err := pull.Pull(db, pattern(t, test.pattern), test.e)
+		if err != nil {
+			t.Fatal(test.pattern, err)
+		}
+		testutil.AreEqual(t, str(t, test.expect), str(t, actual))
+	}
+}
+
+func TestPullMany(t *testing.T) {
+	db, ids := setup(t)
+
+	// entities are resolved like database.ResolveEntid
+	result, err := pull.PullMany(db, []any{kw(":person/name")}, []any{ids["bob"], base.Entity{Id: ids["carol"]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.AreEqual(t, `[{:person/name "Bob"} {:person/name "Carol"}]`, str(t, result))
+}
+
+func TestPullError(t *testing.T) {
+	db, ids := setup(t)
+
+	for _, s := range []string{
+		`:person/name`,
+		`[:person/unknown]`,
+		`[:person/_name]`,
+		`[(:person/name :limit "ten")]`,
+		`[(:person/name :sort true)]`,
+		`[{:person/friend 0}]`,
+		`[{:person/friend :person/name}]`,
+		`["name"]`,
+	} {
+		if _, err := pull.Pull(db, pattern(t, s), ids["alice"]); err == nil {
+			t.Errorf("expected an error for %v", s)
+		}
+	}
+}
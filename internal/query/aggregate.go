@@ -0,0 +1,351 @@
+package query
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/leidegre/datoms/internal/sort"
+)
+
+// AggregateFunc aggregates the values of a variable within a group. args are
+// the constant arguments of the aggregate expression, vals has a value for
+// every tuple in the group which means that it can have duplicates.
+type AggregateFunc func(args []any, vals []any) (any, error)
+
+var (
+	aggregatesLock sync.RWMutex
+	aggregates     = map[string]AggregateFunc{
+		"count":          count,
+		"count-distinct": countDistinct,
+		"sum":            sum,
+		"avg":            avg,
+		"min":            minimum,
+		"max":            maximum,
+		"median":         median,
+		"distinct":       distinctAggregate,
+		"sample":         sample,
+	}
+)
+
+// RegisterAggregate makes fn available as an aggregate function in :find.
+// Safe for concurrent use by multiple goroutines.
+func RegisterAggregate(name string, fn AggregateFunc) {
+	aggregatesLock.Lock()
+	defer aggregatesLock.Unlock()
+	aggregates[name] = fn
+}
+
+func getAggregate(name string) (fn AggregateFunc, ok bool) {
+	aggregatesLock.RLock()
+	defer aggregatesLock.RUnlock()
+	fn, ok = aggregates[name]
+	return
+}
+
+// find is a compiled :find. The slots are the slots of the find elements
+// followed by the slots of the :with variables.
+type find struct {
+	n          int
+	slots      []int
+	aggregates []*aggregate // nil unless there are aggregates, nil for grouping variables
+}
+
+// tuple is the values of the find slots of r
+func (f find) tuple(r row) []any {
+	t := make([]any, len(f.slots))
+	for i, slot := range f.slots {
+		t[i] = r[slot]
+	}
+	return t
+}
+
+type aggregate struct {
+	fn   AggregateFunc
+	args []any
+}
+
+func compileFind(sc *scope, elems []FindElem, with []Var) (find, error) {
+	f := find{n: len(elems)}
+
+	bound := func(v Var) (int, error) {
+		if !sc.isBound(v) {
+			return 0, fmt.Errorf("datoms: find variable %v is not bound", v)
+		}
+		return sc.slot(v), nil
+	}
+
+	for i, elem := range elems {
+		switch elem := elem.(type) {
+		case Var:
+			slot, err := bound(elem)
+			if err != nil {
+				return find{}, err
+			}
+			f.slots = append(f.slots, slot)
+		case Aggregate:
+			fn, ok := getAggregate(elem.Fn)
+			if !ok {
+				return find{}, fmt.Errorf("datoms: unknown aggregate %v", elem.Fn)
+			}
+			if len(elem.Args) == 0 {
+				return find{}, fmt.Errorf("datoms: aggregate %v expects a variable", elem.Fn)
+			}
+			v, ok := elem.Args[len(elem.Args)-1].(Var)
+			if !ok {
+				return find{}, fmt.Errorf("datoms: aggregate %v expects a variable as its last argument", elem.Fn)
+			}
+			slot, err := bound(v)
+			if err != nil {
+				return find{}, err
+			}
+			f.slots = append(f.slots, slot)
+			if f.aggregates == nil {
+				f.aggregates = make([]*aggregate, len(elems))
+			}
+			args := make([]any, len(elem.Args)-1)
+			for j, arg := range elem.Args[:len(elem.Args)-1] {
+				args[j] = sc.term(arg).value
+			}
+			f.aggregates[i] = &aggregate{fn, args}
+		default:
+			return find{}, fmt.Errorf("datoms: unsupported find element %T", elem)
+		}
+	}
+
+	for _, v := range with {
+		slot, err := bound(v)
+		if err != nil {
+			return find{}, err
+		}
+		f.slots = append(f.slots, slot)
+	}
+
+	return f, nil
+}
+
+// aggregate groups the tuples by the find variables that aren't aggregated
+// and aggregates the rest
+func (f find) aggregate(tuples [][]any) ([][]any, error) {
+	var (
+		index  = make(map[string]int)
+		result [][]any
+		vals   [][][]any // group -> find element -> values
+	)
+
+	for _, t := range tuples {
+		var b []byte
+		for i, agg := range f.aggregates {
+			if agg == nil {
+				b = appendKey(b, t[i])
+			}
+		}
+		k := string(b)
+
+		g, ok := index[k]
+		if !ok {
+			g = len(result)
+			index[k] = g
+			result = append(result, t[:f.n:f.n])
+			vals = append(vals, make([][]any, f.n))
+		}
+		for i, agg := range f.aggregates {
+			if agg != nil {
+				vals[g][i] = append(vals[g][i], t[i])
+			}
+		}
+	}
+
+	for g, t := range result {
+		out := make([]any, f.n)
+		for i, agg := range f.aggregates {
+			if agg == nil {
+				out[i] = t[i]
+				continue
+			}
+			v, err := agg.fn(agg.args, vals[g][i])
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		result[g] = out
+	}
+
+	return result, nil
+}
+
+func noArgs(name string, args []any) error {
+	if len(args) != 0 {
+		return fmt.Errorf("datoms: aggregate %v expects 1 argument got %v", name, len(args)+1)
+	}
+	return nil
+}
+
+// limit is the optional leading argument of aggregates like (min 3 ?x)
+func limit(name string, args []any, required bool) (n int, ok bool, err error) {
+	switch {
+	case len(args) == 0 && !required:
+		return 0, false, nil
+	case len(args) == 1:
+		if n, ok := args[0].(int64); ok && 0 <= n {
+			return int(n), true, nil
+		}
+		return 0, false, fmt.Errorf("datoms: aggregate %v expects a non-negative number got %v", name, args[0])
+	default:
+		return 0, false, fmt.Errorf("datoms: aggregate %v has unexpected arguments %v", name, args)
+	}
+}
+
+func distinct(vals []any) []any {
+	var (
+		seen = make(map[string]bool)
+		out  []any
+	)
+	for _, v := range vals {
+		k := string(appendKey(nil, v))
+		if !seen[k] {
+			seen[k] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// sortValues sorts values of the same type
+func sortValues(name string, vals []any) ([]any, error) {
+	for _, v := range vals {
+		if !isOrdered(v) {
+			return nil, fmt.Errorf("datoms: aggregate %v cannot compare %T", name, v)
+		}
+		if reflect.TypeOf(v) != reflect.TypeOf(vals[0]) {
+			return nil, fmt.Errorf("datoms: aggregate %v cannot compare %T and %T", name, vals[0], v)
+		}
+	}
+	vals = slices.Clone(vals)
+	slices.SortFunc(vals, sort.CompareValue)
+	return vals, nil
+}
+
+func count(args []any, vals []any) (any, error) {
+	if err := noArgs("count", args); err != nil {
+		return nil, err
+	}
+	return int64(len(vals)), nil
+}
+
+func countDistinct(args []any, vals []any) (any, error) {
+	if err := noArgs("count-distinct", args); err != nil {
+		return nil, err
+	}
+	return int64(len(distinct(vals))), nil
+}
+
+// sum is a long if all values are longs and a double otherwise
+func sum(args []any, vals []any) (any, error) {
+	if err := noArgs("sum", args); err != nil {
+		return nil, err
+	}
+	var (
+		l        int64
+		d        float64
+		isDouble bool
+	)
+	for _, v := range vals {
+		switch v := v.(type) {
+		case int64:
+			l += v
+		case float64:
+			d += v
+			isDouble = true
+		default:
+			return nil, fmt.Errorf("datoms: aggregate sum expects numbers got %T", v)
+		}
+	}
+	if isDouble {
+		return d + float64(l), nil
+	}
+	return l, nil
+}
+
+func avg(args []any, vals []any) (any, error) {
+	if err := noArgs("avg", args); err != nil {
+		return nil, err
+	}
+	s, err := sum(nil, vals)
+	if err != nil {
+		return nil, err
+	}
+	switch s := s.(type) {
+	case int64:
+		return float64(s) / float64(len(vals)), nil
+	default:
+		return s.(float64) / float64(len(vals)), nil
+	}
+}
+
+// extremes is the n smallest or largest distinct values or the smallest or largest value
+func extremes(name string, args []any, vals []any, largest bool) (any, error) {
+	n, ok, err := limit(name, args, false)
+	if err != nil {
+		return nil, err
+	}
+	vals, err = sortValues(name, distinct(vals))
+	if err != nil {
+		return nil, err
+	}
+	if largest {
+		slices.Reverse(vals)
+	}
+	if ok {
+		return vals[:min(n, len(vals))], nil
+	}
+	return vals[0], nil
+}
+
+func minimum(args []any, vals []any) (any, error) {
+	return extremes("min", args, vals, false)
+}
+
+func maximum(args []any, vals []any) (any, error) {
+	return extremes("max", args, vals, true)
+}
+
+// median is the middle value. With an even number of values it's the mean
+// of the two middle values, which is a double.
+func median(args []any, vals []any) (any, error) {
+	if err := noArgs("median", args); err != nil {
+		return nil, err
+	}
+	vals, err := sortValues("median", vals)
+	if err != nil {
+		return nil, err
+	}
+	m := len(vals) / 2
+	if len(vals)%2 == 1 {
+		return vals[m], nil
+	}
+	return avg(nil, vals[m-1:m+1])
+}
+
+func distinctAggregate(args []any, vals []any) (any, error) {
+	if err := noArgs("distinct", args); err != nil {
+		return nil, err
+	}
+	return distinct(vals), nil
+}
+
+// sample is up to n distinct values picked at random
+func sample(args []any, vals []any) (any, error) {
+	n, _, err := limit("sample", args, true)
+	if err != nil {
+		return nil, err
+	}
+	vals = distinct(vals)
+	rand.Shuffle(len(vals), func(i, j int) {
+		vals[i], vals[j] = vals[j], vals[i]
+	})
+	return vals[:min(n, len(vals))], nil
+}
@@ -0,0 +1,150 @@
+package query_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/leidegre/datoms/internal/query"
+	"github.com/leidegre/datoms/testutil"
+)
+
+func aggregate(fn string, args ...any) query.Aggregate {
+	return query.Aggregate{Fn: fn, Args: args}
+}
+
+func TestAggregate(t *testing.T) {
+	db := newDatabase(t)
+
+	where := []query.Clause{
+		query.Pattern{query.Var("?e"), personAge, query.Var("?age")},
+	}
+
+	cases := []struct {
+		fn       query.Aggregate
+		expected any
+	}{
+		{aggregate("count", query.Var("?e")), int64(5)},
+		{aggregate("sum", query.Var("?age")), int64(210)},
+		{aggregate("avg", query.Var("?age")), float64(42)},
+		{aggregate("min", query.Var("?age")), int64(25)},
+		{aggregate("max", query.Var("?age")), int64(60)},
+		{aggregate("median", query.Var("?age")), int64(45)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.fn.Fn, func(t *testing.T) {
+			result := q(t, query.Query{
+				Find:  []query.FindElem{c.fn},
+				Where: where,
+			}, db)
+			testutil.AreEqual(t, 1, len(result))
+			testutil.AreEqual(t, c.expected, result[0][0])
+		})
+	}
+
+	t.Run("min n", func(t *testing.T) {
+		result := q(t, query.Query{
+			Find:  []query.FindElem{aggregate("min", 2, query.Var("?age"))},
+			Where: where,
+		}, db)
+		testutil.AreEqual(t, "[25 30]", fmt.Sprint(result[0][0]))
+	})
+
+	t.Run("max n", func(t *testing.T) {
+		result := q(t, query.Query{
+			Find:  []query.FindElem{aggregate("max", 2, query.Var("?age"))},
+			Where: where,
+		}, db)
+		testutil.AreEqual(t, "[60 50]", fmt.Sprint(result[0][0]))
+	})
+
+	t.Run("sample", func(t *testing.T) {
+		result := q(t, query.Query{
+			Find:  []query.FindElem{aggregate("sample", 3, query.Var("?age"))},
+			Where: where,
+		}, db)
+		testutil.AreEqual(t, 3, len(result[0][0].([]any)))
+	})
+}
+
+func TestAggregateGrouping(t *testing.T) {
+	db := newDatabase(t)
+
+	result := q(t, query.Query{
+		Find: []query.FindElem{query.Var("?manager"), aggregate("count", query.Var("?e")), aggregate("distinct", query.Var("?name"))},
+		Where: []query.Clause{
+			query.Pattern{query.Var("?e"), personManager, query.Var("?m")},
+			query.Pattern{query.Var("?m"), personName, query.Var("?manager")},
+			query.Pattern{query.Var("?e"), personName, query.Var("?name")},
+		},
+	}, db)
+
+	testutil.AreEqual(t, 3, len(result))
+	testutil.AreEqual(t, "[ceo 2 [vp1 vp2]]", fmt.Sprint(result[0]))
+	testutil.AreEqual(t, "[eng1 1 [eng2]]", fmt.Sprint(result[1]))
+	testutil.AreEqual(t, "[vp1 1 [eng1]]", fmt.Sprint(result[2]))
+}
+
+func TestAggregateWith(t *testing.T) {
+	db := newDatabase(t)
+
+	// vp1 and vp2 have the same manager
+	managers := func(q query.Query) int64 {
+		q.Where = []query.Clause{
+			query.Pattern{query.Var("?e"), personManager, query.Var("?m")},
+		}
+		result, err := query.Q(q, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result[0][0].(int64)
+	}
+
+	testutil.AreEqual(t, int64(3), managers(query.Query{
+		Find: []query.FindElem{aggregate("count", query.Var("?m"))},
+	}))
+	testutil.AreEqual(t, int64(4), managers(query.Query{
+		Find: []query.FindElem{aggregate("count", query.Var("?m"))},
+		With: []query.Var{"?e"},
+	}))
+	testutil.AreEqual(t, int64(3), managers(query.Query{
+		Find: []query.FindElem{aggregate("count-distinct", query.Var("?m"))},
+		With: []query.Var{"?e"},
+	}))
+}
+
+func TestRegisterAggregate(t *testing.T) {
+	db := newDatabase(t)
+
+	query.RegisterAggregate("test/longest", func(args []any, vals []any) (any, error) {
+		var longest string
+		for _, v := range vals {
+			if s := v.(string); len(longest) < len(s) {
+				longest = s
+			}
+		}
+		return longest, nil
+	})
+
+	result := q(t, query.Query{
+		Find: []query.FindElem{aggregate("test/longest", query.Var("?name"))},
+		Where: []query.Clause{
+			query.Pattern{query.Var("?e"), personName, query.Var("?name")},
+		},
+	}, db)
+
+	testutil.AreEqual(t, 4, len(result[0][0].(string)))
+}
+
+func TestUnknownAggregate(t *testing.T) {
+	_, err := query.Q(query.Query{
+		Find: []query.FindElem{aggregate("foo", query.Var("?e"))},
+		Where: []query.Clause{
+			query.Pattern{query.Var("?e"), personName},
+		},
+	}, newDatabase(t))
+
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,156 @@
+package query
+
+import (
+	"container/list"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/leidegre/datoms/internal/database"
+)
+
+// Cache is a least recently used cache of query results. Database values are
+// immutable so a query that runs with the same inputs against the same
+// database value and basis T always has the same result. A cache can be
+// shared by any number of goroutines.
+type Cache struct {
+	pool       *Pool
+	maxEntries int // 0 is no limit
+	maxTuples  int // 0 is no limit
+
+	mu      sync.Mutex
+	lru     *list.List // front is most recently used
+	entries map[string]*list.Element
+	stats   CacheStats
+}
+
+// CacheStats are the metrics of a cache
+type CacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Entries   int // results in the cache
+	Tuples    int // tuples of the results in the cache
+}
+
+type cacheEntry struct {
+	key    string
+	dbs    []database.Interface // keeps the addresses in the key from being reused
+	result [][]any
+}
+
+// NewCache makes a cache that runs queries on pool. The cache evicts the
+// least recently used results when it has more than maxEntries results or
+// more than maxTuples tuples, a limit of 0 is no limit.
+func NewCache(pool *Pool, maxEntries, maxTuples int) *Cache {
+	return &Cache{
+		pool:       pool,
+		maxEntries: maxEntries,
+		maxTuples:  maxTuples,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// Q runs a query like Q unless the result is in the cache. The tuples of the
+// result are shared and must not be modified.
+func (c *Cache) Q(q Query, inputs ...any) ([][]any, error) {
+	key, dbs, ok := cacheKey(q, inputs)
+	if !ok {
+		c.mu.Lock()
+		c.stats.Misses++
+		c.mu.Unlock()
+		return c.pool.Q(q, inputs...)
+	}
+
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok && slices.Equal(elem.Value.(*cacheEntry).dbs, dbs) {
+		c.lru.MoveToFront(elem)
+		c.stats.Hits++
+		result := elem.Value.(*cacheEntry).result
+		c.mu.Unlock()
+		return append([][]any(nil), result...), nil
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	result, err := c.pool.Q(q, inputs...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.add(key, dbs, result)
+	return append([][]any(nil), result...), nil
+}
+
+func (c *Cache) add(key string, dbs []database.Interface, result [][]any) {
+	if 0 < c.maxTuples && c.maxTuples < len(result) {
+		return // would evict everything
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if slices.Equal(entry.dbs, dbs) {
+			return // another goroutine got there first
+		}
+		c.lru.Remove(elem)
+		c.stats.Entries--
+		c.stats.Tuples -= len(entry.result)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key, dbs, result})
+	c.stats.Entries++
+	c.stats.Tuples += len(result)
+
+	for (0 < c.maxEntries && c.maxEntries < c.stats.Entries) || (0 < c.maxTuples && c.maxTuples < c.stats.Tuples) {
+		entry := c.lru.Remove(c.lru.Back()).(*cacheEntry)
+		delete(c.entries, entry.key)
+		c.stats.Entries--
+		c.stats.Tuples -= len(entry.result)
+		c.stats.Evictions++
+	}
+}
+
+// Stats reports the metrics of the cache
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Purge removes every result from the cache
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.stats.Entries, c.stats.Tuples = 0, 0
+}
+
+// cacheKey identifies a query and its inputs. Databases are identified by
+// their address and basis T, views like AsOf and History are different
+// values of the same basis. The databases are returned so that the entry can
+// hold on to them and compare them on lookup. It reports false if an input
+// can't be identified.
+func cacheKey(q Query, inputs []any) (key string, dbs []database.Interface, ok bool) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%#v", q)
+	for _, in := range inputs {
+		sb.WriteByte('|')
+		if db, ok := in.(database.Interface); ok {
+			if reflect.ValueOf(db).Kind() != reflect.Pointer {
+				return "", nil, false
+			}
+			baseT, nextT := db.T()
+			fmt.Fprintf(&sb, "%T:%p@%v/%v", db, db, baseT, nextT)
+			dbs = append(dbs, db)
+			continue
+		}
+		fmt.Fprintf(&sb, "%#v", in)
+	}
+	return sb.String(), dbs, true
+}
@@ -0,0 +1,120 @@
+package query_test
+
+import (
+	"testing"
+
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/internal/query"
+	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/testutil"
+)
+
+var namesQuery = query.Query{
+	Find: []query.FindElem{query.Var("?name")},
+	Where: []query.Clause{
+		query.Pattern{query.Var("?e"), personName, query.Var("?name")},
+	},
+}
+
+func TestCache(t *testing.T) {
+	db := newDatabase(t)
+	cache := query.NewCache(query.DefaultPool, 0, 0)
+
+	for i := 0; i < 3; i++ {
+		result, err := cache.Q(namesQuery, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.AreEqual(t, len(people), len(result))
+	}
+
+	stats := cache.Stats()
+	testutil.AreEqual(t, int64(2), stats.Hits)
+	testutil.AreEqual(t, int64(1), stats.Misses)
+	testutil.AreEqual(t, 1, stats.Entries)
+	testutil.AreEqual(t, len(people), stats.Tuples)
+
+	// a new basis
+	after := transact(t, db, database.Add(base.NewTempId(schema.DbPartUser), personName, "eng3")).DbAfter
+	result, err := cache.Q(namesQuery, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.AreEqual(t, len(people)+1, len(result))
+
+	// a different view of the same basis
+	_, nextT := after.T()
+	result, err = cache.Q(namesQuery, after.AsOf(nextT-2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.AreEqual(t, len(people), len(result))
+
+	// different inputs
+	if _, err := cache.Q(query.Query{
+		Find: []query.FindElem{query.Var("?e")},
+		In:   []query.Binding{query.DefaultSrc, query.Var("?name")},
+		Where: []query.Clause{
+			query.Pattern{query.Var("?e"), personName, query.Var("?name")},
+		},
+	}, db, "ceo"); err != nil {
+		t.Fatal(err)
+	}
+
+	stats = cache.Stats()
+	testutil.AreEqual(t, int64(2), stats.Hits)
+	testutil.AreEqual(t, int64(4), stats.Misses)
+	testutil.AreEqual(t, 4, stats.Entries)
+}
+
+func TestCacheEviction(t *testing.T) {
+	db := newDatabase(t)
+
+	byName := func(name string) query.Query {
+		return query.Query{
+			Find: []query.FindElem{query.Var("?e")},
+			Where: []query.Clause{
+				query.Pattern{query.Var("?e"), personName, name},
+			},
+		}
+	}
+
+	cache := query.NewCache(query.DefaultPool, 2, 0)
+	for _, p := range people {
+		if _, err := cache.Q(byName(p.name), db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cache.Q(byName(people[len(people)-1].name), db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Q(byName(people[0].name), db); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := cache.Stats()
+	testutil.AreEqual(t, int64(1), stats.Hits)
+	testutil.AreEqual(t, int64(len(people)+1), stats.Misses)
+	testutil.AreEqual(t, int64(len(people)-1), stats.Evictions)
+	testutil.AreEqual(t, 2, stats.Entries)
+
+	// results that are larger than the cache aren't cached
+	cache = query.NewCache(query.DefaultPool, 0, len(people)-1)
+	for i := 0; i < 2; i++ {
+		if _, err := cache.Q(namesQuery, db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cache.Q(byName(people[0].name), db); err != nil {
+		t.Fatal(err)
+	}
+
+	stats = cache.Stats()
+	testutil.AreEqual(t, int64(0), stats.Hits)
+	testutil.AreEqual(t, 1, stats.Entries)
+	testutil.AreEqual(t, 1, stats.Tuples)
+
+	cache.Purge()
+	testutil.AreEqual(t, 0, cache.Stats().Entries)
+}
@@ -0,0 +1,169 @@
+package query
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/leidegre/datoms/iter"
+)
+
+// collectVars finds the variables of clause that unify with the surrounding clauses
+func collectVars(vars []Var, clause Clause) []Var {
+	add := func(args []any) {
+		for _, arg := range args {
+			if v, ok := arg.(Var); ok && v != Blank {
+				vars = append(vars, v)
+			}
+		}
+	}
+	switch clause := clause.(type) {
+	case Pattern:
+		add(clause)
+	case RuleExpr:
+		add(clause.Args)
+	case Not:
+		for _, clause := range clause.Clauses {
+			vars = collectVars(vars, clause)
+		}
+	case NotJoin:
+		vars = append(vars, clause.Vars...)
+	case Or:
+		for _, clause := range clause.Clauses {
+			vars = collectVars(vars, clause)
+		}
+	case OrJoin:
+		vars = append(vars, clause.Vars...)
+	case And:
+		for _, clause := range clause {
+			vars = collectVars(vars, clause)
+		}
+	case Predicate:
+		add(clause.Args)
+	case Function:
+		add(clause.Args)
+		add([]any{clause.Bind})
+	case join:
+		for _, p := range clause {
+			add(p)
+		}
+	}
+	return vars
+}
+
+// distinctVars sorts and removes duplicates
+func distinctVars(vars []Var) []Var {
+	vars = slices.Clone(vars)
+	slices.Sort(vars)
+	return slices.Compact(vars)
+}
+
+func (c *compiler) not(sc *scope, not Not) (op, error) {
+	var vars []Var
+	for _, clause := range not.Clauses {
+		vars = collectVars(vars, clause)
+	}
+	vars = distinctVars(vars)
+	for _, v := range vars {
+		if !sc.isBound(v) {
+			return nil, fmt.Errorf("datoms: not variable %v is not bound", v)
+		}
+	}
+	return c.notJoin(sc, NotJoin{vars, not.Clauses})
+}
+
+func (c *compiler) notJoin(sc *scope, not NotJoin) (op, error) {
+	for _, v := range not.Vars {
+		if !sc.isBound(v) {
+			return nil, fmt.Errorf("datoms: not-join variable %v is not bound", v)
+		}
+	}
+
+	ops, err := c.compile(sc.nested(not.Vars), not.Clauses)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(in iter.Seq[row]) iter.Seq[row] {
+		return func(yield func(row) bool) {
+			in(func(r row) bool {
+				match := false
+				from(r, ops)(func(row) bool {
+					match = true
+					return false
+				})
+				return match || yield(r)
+			})
+		}
+	}, nil
+}
+
+func (c *compiler) or(sc *scope, or Or) (op, error) {
+	var vars []Var
+	for i, clause := range or.Clauses {
+		branch := distinctVars(collectVars(nil, clause))
+		if i == 0 {
+			vars = branch
+		} else if !slices.Equal(vars, branch) {
+			return nil, fmt.Errorf("datoms: or clauses must use the same variables %v and %v", vars, branch)
+		}
+	}
+	return c.orJoin(sc, OrJoin{vars, or.Clauses})
+}
+
+func (c *compiler) orJoin(sc *scope, or OrJoin) (op, error) {
+	if len(or.Clauses) == 0 {
+		return nil, fmt.Errorf("datoms: or-join without clauses")
+	}
+
+	branches := make([][]op, len(or.Clauses))
+	for i, clause := range or.Clauses {
+		nested := sc.nested(or.Vars)
+		ops, err := c.compile(nested, []Clause{clause})
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range or.Vars {
+			if !nested.isBound(v) {
+				return nil, fmt.Errorf("datoms: or-join variable %v is not bound by %v", v, clause)
+			}
+		}
+		branches[i] = ops
+	}
+
+	sc.bind(or.Vars)
+
+	slots := make([]int, len(or.Vars))
+	for i, v := range or.Vars {
+		slots[i] = sc.slot(v)
+	}
+
+	return func(in iter.Seq[row]) iter.Seq[row] {
+		return func(yield func(row) bool) {
+			in(func(r row) bool {
+				var (
+					seen = make(map[string]bool)
+					ok   = true
+				)
+				for _, ops := range branches {
+					from(r, ops)(func(branch row) bool {
+						var k []byte
+						out := append(row(nil), r...)
+						for _, slot := range slots {
+							out[slot] = branch[slot]
+							k = appendKey(k, out[slot])
+						}
+						if !seen[string(k)] {
+							seen[string(k)] = true
+							ok = yield(out)
+						}
+						return ok
+					})
+					if !ok {
+						return false
+					}
+				}
+				return true
+			})
+		}
+	}, nil
+}
@@ -0,0 +1,120 @@
+package query_test
+
+import (
+	"testing"
+
+	"github.com/leidegre/datoms/internal/query"
+	"github.com/leidegre/datoms/testutil"
+)
+
+func names(t *testing.T, where ...query.Clause) []string {
+	return strings(q(t, query.Query{
+		Find:  []query.FindElem{query.Var("?name")},
+		Where: append(where, query.Pattern{query.Var("?e"), personName, query.Var("?name")}),
+	}, newDatabase(t)))
+}
+
+func TestNot(t *testing.T) {
+	// people without reports
+	testutil.AreEqualSlice(t, []string{"eng2", "vp2"}, names(t,
+		query.Pattern{query.Var("?e"), personAge},
+		query.Not{Clauses: []query.Clause{
+			query.Pattern{query.Blank, personManager, query.Var("?e")},
+		}},
+	))
+}
+
+func TestNotJoin(t *testing.T) {
+	// people whose manager doesn't have a manager
+	testutil.AreEqualSlice(t, []string{"vp1", "vp2"}, names(t,
+		query.Pattern{query.Var("?e"), personManager, query.Var("?m")},
+		query.NotJoin{Vars: []query.Var{"?m"}, Clauses: []query.Clause{
+			query.Pattern{query.Var("?m"), personManager, query.Var("?x")},
+		}},
+	))
+}
+
+func TestNotUnbound(t *testing.T) {
+	_, err := query.Q(query.Query{
+		Find: []query.FindElem{query.Var("?e")},
+		Where: []query.Clause{
+			query.Pattern{query.Var("?e"), personName},
+			query.Not{Clauses: []query.Clause{
+				query.Pattern{query.Var("?e"), personManager, query.Var("?m")},
+			}},
+		},
+	}, newDatabase(t))
+
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestOr(t *testing.T) {
+	testutil.AreEqualSlice(t, []string{"ceo", "eng2"}, names(t,
+		query.Or{Clauses: []query.Clause{
+			query.And{
+				query.Pattern{query.Var("?e"), personAge, query.Var("?age")},
+				query.Predicate{Fn: ">", Args: []any{query.Var("?age"), 55}},
+			},
+			query.And{
+				query.Pattern{query.Var("?e"), personAge, query.Var("?age")},
+				query.Predicate{Fn: "<", Args: []any{query.Var("?age"), 30}},
+			},
+		}},
+	))
+}
+
+func TestOrDifferentVars(t *testing.T) {
+	_, err := query.Q(query.Query{
+		Find: []query.FindElem{query.Var("?e")},
+		Where: []query.Clause{
+			query.Or{Clauses: []query.Clause{
+				query.Pattern{query.Var("?e"), personName, "ceo"},
+				query.Pattern{query.Var("?e"), personAge, query.Var("?age")},
+			}},
+		},
+	}, newDatabase(t))
+
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestOrJoin(t *testing.T) {
+	// people who are either 60 or report to someone who is 50
+	testutil.AreEqualSlice(t, []string{"ceo", "eng1"}, names(t,
+		query.OrJoin{Vars: []query.Var{"?e"}, Clauses: []query.Clause{
+			query.Pattern{query.Var("?e"), personAge, 60},
+			query.And{
+				query.Pattern{query.Var("?e"), personManager, query.Var("?m")},
+				query.Pattern{query.Var("?m"), personAge, 50},
+			},
+		}},
+	))
+}
+
+func TestNegatedRecursion(t *testing.T) {
+	rules := []query.Rule{
+		{
+			Name: "foo",
+			Vars: []query.Var{"?e"},
+			Body: []query.Clause{
+				query.Pattern{query.Var("?e"), personName},
+				query.Not{Clauses: []query.Clause{
+					query.RuleExpr{Name: "foo", Args: []any{query.Var("?e")}},
+				}},
+			},
+		},
+	}
+
+	_, err := query.Q(query.Query{
+		Find:  []query.FindElem{query.Var("?e")},
+		In:    []query.Binding{query.DefaultSrc, query.Rules},
+		Where: []query.Clause{query.RuleExpr{Name: "foo", Args: []any{query.Var("?e")}}},
+	}, newDatabase(t), rules)
+
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
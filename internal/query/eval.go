@@ -0,0 +1,536 @@
+package query
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/iter"
+	"github.com/leidegre/datoms/symbol"
+)
+
+// A row has a slot for every variable, a nil slot is unbound
+type row = []any
+
+// op is a compiled clause, it extends or filters every row it's given
+type op func(in iter.Seq[row]) iter.Seq[row]
+
+// scope assigns slots to variables and tracks which variables are bound
+type scope struct {
+	slots map[Var]int
+	bound map[Var]bool
+	n     *int // slots, shared with nested scopes
+}
+
+func newScope() *scope {
+	return &scope{slots: make(map[Var]int), bound: make(map[Var]bool), n: new(int)}
+}
+
+func (sc *scope) slot(v Var) int {
+	if i, ok := sc.slots[v]; ok {
+		return i
+	}
+	i := *sc.n
+	*sc.n++
+	sc.slots[v] = i
+	return i
+}
+
+func (sc *scope) isBound(v Var) bool {
+	return sc.bound[v]
+}
+
+func (sc *scope) bind(vars []Var) {
+	for _, v := range vars {
+		if v != Blank {
+			sc.slot(v)
+			sc.bound[v] = true
+		}
+	}
+}
+
+// nested is a scope that only shares vars with sc, any other variable is
+// local to the nested scope
+func (sc *scope) nested(vars []Var) *scope {
+	nested := &scope{slots: make(map[Var]int), bound: make(map[Var]bool), n: sc.n}
+	for _, v := range vars {
+		nested.slots[v] = sc.slot(v)
+		nested.bound[v] = sc.bound[v]
+	}
+	return nested
+}
+
+// engine evaluates a single query
+type engine struct {
+	pool  *Pool
+	srcs  map[Src]database.Interface
+	rels  map[Src]*relation // sources that are relations
+	input []input
+	rules map[string][]Rule
+	full  map[string]*relation // rule relations
+	delta map[string]*relation // new tuples of recursive rules in the last iteration
+	mu    sync.Mutex
+	err   error // the first error that stopped evaluation
+
+	explain bool
+	plan    []Step
+}
+
+// fail stops evaluation, the error is reported once evaluation has stopped
+func (e *engine) fail(err error) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.err == nil {
+		e.err = err
+	}
+	return false
+}
+
+func newEngine(pool *Pool, in []Binding, inputs []any) (*engine, error) {
+	if in == nil {
+		in = []Binding{DefaultSrc}
+	}
+	if len(in) != len(inputs) {
+		return nil, fmt.Errorf("datoms: query expects %v inputs got %v", len(in), len(inputs))
+	}
+	e := &engine{
+		pool:  pool,
+		srcs:  make(map[Src]database.Interface),
+		rels:  make(map[Src]*relation),
+		full:  make(map[string]*relation),
+		delta: make(map[string]*relation),
+	}
+	for i, b := range in {
+		switch b := b.(type) {
+		case Src:
+			if db, ok := inputs[i].(database.Interface); ok {
+				e.srcs[b] = db
+				continue
+			}
+			tuples, err := tuples(b, inputs[i], -1)
+			if err != nil {
+				return nil, fmt.Errorf("datoms: input %v is not a database or a relation", b)
+			}
+			rel := newRelation(0)
+			for _, t := range tuples {
+				rel.arity = len(t)
+				rel.add(t)
+			}
+			e.rels[b] = rel
+		case RulesVar:
+			rules, ok := inputs[i].([]Rule)
+			if !ok {
+				return nil, fmt.Errorf("datoms: input %v is not a []Rule", b)
+			}
+			e.rules = make(map[string][]Rule)
+			for _, rule := range rules {
+				if others := e.rules[rule.Name]; 0 < len(others) && len(others[0].Vars) != len(rule.Vars) {
+					return nil, fmt.Errorf("datoms: rule %v has inconsistent arity", rule.Name)
+				}
+				e.rules[rule.Name] = append(e.rules[rule.Name], rule)
+			}
+		default:
+			in, err := bindInput(b, inputs[i])
+			if err != nil {
+				return nil, err
+			}
+			e.input = append(e.input, in)
+		}
+	}
+	return e, nil
+}
+
+func (e *engine) run(q Query) ([][]any, error) {
+	find, rows, err := e.prepare(q)
+	if err != nil {
+		return nil, err
+	}
+	return e.collect(find, rows)
+}
+
+// collect evaluates every row and aggregates the result
+func (e *engine) collect(find find, rows iter.Seq[row]) ([][]any, error) {
+	result := newRelation(len(find.slots))
+	rows(func(r row) bool {
+		result.add(find.tuple(r))
+		return true
+	})
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	if find.aggregates == nil {
+		return result.tuples, nil
+	}
+	return find.aggregate(result.tuples)
+}
+
+// prepare compiles the query and evaluates the rules that it invokes, rows
+// evaluates the where clauses
+func (e *engine) prepare(q Query) (find find, rows iter.Seq[row], err error) {
+	sc := newScope()
+	in := e.bindInputs(sc)
+
+	c := &compiler{engine: e, rel: e.relations}
+	steps := c.plan(sc, q.Where)
+	ops, err := c.compileSteps(sc, steps)
+	if err != nil {
+		return find, nil, err
+	}
+	if e.explain {
+		e.plan = steps
+		ops = e.count(ops)
+	}
+
+	if find, err = compileFind(sc, q.Find, q.With); err != nil {
+		return find, nil, err
+	}
+
+	if err := e.evalRules(q.Where); err != nil {
+		return find, nil, err
+	}
+
+	return find, e.eval(*sc.n, in, ops), nil
+}
+
+// eval runs the compiled clauses starting with the rows of the inputs, every
+// clause after the first is evaluated on the workers of the pool
+func (e *engine) eval(n int, in []op, ops []op) iter.Seq[row] {
+	seq := from(make(row, n), in)
+	if len(ops) == 0 {
+		return seq
+	}
+	return e.pool.pipeline(ops[0](seq), ops[1:])
+}
+
+// from runs the compiled clauses starting with r
+func from(r row, ops []op) iter.Seq[row] {
+	seq := func(yield func(row) bool) {
+		yield(r)
+	}
+	for _, op := range ops {
+		seq = op(seq)
+	}
+	return seq
+}
+
+// relations is how rule invocations find rule relations once rules have been evaluated
+func (e *engine) relations(name string, _ int) *relation {
+	return e.full[name]
+}
+
+// compiler compiles clauses into ops. Rule invocations are numbered in the
+// order that they are compiled, which is the planned order and not the order
+// of the clauses, rel is how they find their rule relation.
+type compiler struct {
+	*engine
+	rel   func(name string, call int) *relation
+	calls []string // the rules that are invoked, by number
+}
+
+func (c *compiler) compile(sc *scope, clauses []Clause) ([]op, error) {
+	return c.compileSteps(sc, c.plan(sc, clauses))
+}
+
+// compileSteps compiles one op for every step
+func (c *compiler) compileSteps(sc *scope, steps []Step) ([]op, error) {
+	var ops []op
+	for _, step := range steps {
+		var (
+			op  op
+			err error
+		)
+		switch clause := step.Clause.(type) {
+		case Pattern:
+			op, err = c.pattern(sc, clause)
+		case RuleExpr:
+			op, err = c.ruleExpr(sc, clause)
+		case Not:
+			op, err = c.not(sc, clause)
+		case NotJoin:
+			op, err = c.notJoin(sc, clause)
+		case Or:
+			op, err = c.or(sc, clause)
+		case OrJoin:
+			op, err = c.orJoin(sc, clause)
+		case Predicate:
+			op, err = c.predicate(sc, clause)
+		case Function:
+			op, err = c.function(sc, clause)
+		case join:
+			op, err = c.join(sc, clause)
+		default:
+			err = fmt.Errorf("datoms: unsupported clause %T", clause)
+		}
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// term is a compiled pattern or rule argument
+type term struct {
+	slot  int // -1 for constants and blanks
+	value any // constant
+}
+
+func (sc *scope) term(v any) term {
+	switch v := v.(type) {
+	case Var:
+		if v == Blank {
+			return term{slot: -1}
+		}
+		return term{slot: sc.slot(v)}
+	case int:
+		return term{slot: -1, value: int64(v)}
+	default:
+		return term{slot: -1, value: v}
+	}
+}
+
+// resolve resolves the term against row, nil is unbound
+func (t term) resolve(r row) any {
+	if 0 <= t.slot {
+		return r[t.slot]
+	}
+	return t.value
+}
+
+// bind binds the term to v or checks that it's already bound to v
+func (t term) bind(r row, v any) bool {
+	if t.slot < 0 {
+		return t.value == nil || equal(t.value, v)
+	}
+	if r[t.slot] == nil {
+		r[t.slot] = v
+		return true
+	}
+	return equal(r[t.slot], v)
+}
+
+func (e *engine) source(src Src) (database.Interface, error) {
+	if db, ok := e.srcs[src]; ok {
+		return db, nil
+	}
+	return nil, fmt.Errorf("datoms: unknown source %v", src)
+}
+
+// entid resolves idents to entity IDs
+func entid(s schema.Interface, v any) (any, error) {
+	if kw, ok := v.(symbol.Keyword); ok {
+		if id, ok := s.Id(kw); ok {
+			return id, nil
+		}
+		return nil, fmt.Errorf("datoms: cannot resolve %v", kw)
+	}
+	return v, nil
+}
+
+func (c *compiler) pattern(sc *scope, p Pattern) (op, error) {
+	src := DefaultSrc
+	if 0 < len(p) {
+		if s, ok := p[0].(Src); ok {
+			src, p = s, p[1:]
+		}
+	}
+	if rel, ok := c.rels[src]; ok {
+		return c.relPattern(sc, src, rel, p)
+	}
+	if !(1 <= len(p) && len(p) <= 4) {
+		return nil, fmt.Errorf("datoms: invalid pattern %v", p)
+	}
+
+	db, err := c.source(src)
+	if err != nil {
+		return nil, err
+	}
+
+	s := db.Schema()
+
+	var terms [4]term
+	for i := range terms {
+		if i < len(p) {
+			terms[i] = sc.term(p[i])
+		} else {
+			terms[i] = term{slot: -1}
+		}
+	}
+
+	// constant E and A can be idents
+	if terms[0].value, err = entid(s, terms[0].value); err != nil {
+		return nil, err
+	}
+	if terms[1].value, err = entid(s, terms[1].value); err != nil {
+		return nil, err
+	}
+
+	// constant V can be an ident if the attribute is a ref
+	if a, ok := terms[1].value.(int64); ok {
+		refType, _ := s.Id(schema.DbTypeRef)
+		if attr, ok := s.Attr(a); ok && attr.ValueType == refType {
+			if terms[2].value, err = entid(s, terms[2].value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sc.bind(collectVars(nil, p))
+
+	return func(in iter.Seq[row]) iter.Seq[row] {
+		return func(yield func(row) bool) {
+			in(func(r row) bool {
+				ok := true
+				scan(db, s, terms[0].resolve(r), terms[1].resolve(r), terms[2].resolve(r))(func(d base.Datom) bool {
+					if !ok {
+						return false
+					}
+					out := append(row(nil), r...)
+					if terms[0].bind(out, d.E) && terms[1].bind(out, d.A) && terms[2].bind(out, d.V) && terms[3].bind(out, d.Tx()) {
+						ok = yield(out)
+					}
+					return ok
+				})
+				return ok
+			})
+		}
+	}, nil
+}
+
+// chooseIndex picks the index to scan based on whether E, A and V are bound
+// and whether A is a ref attribute. V can't be used unless A is bound.
+func chooseIndex(e, a, v, ref bool) base.Index {
+	switch {
+	case e:
+		return base.EAVT
+	case a && v && ref:
+		return base.VAET
+	case a && v:
+		return base.AVET
+	case a:
+		return base.AEVT
+	default:
+		return base.EAVT
+	}
+}
+
+// scan scans the index that is the best fit for what's bound, the datoms
+// that it yields can still mismatch
+func scan(db database.Interface, s schema.Interface, e, a, v any) iter.Seq[base.Datom] {
+	if e != nil {
+		if _, ok := e.(int64); !ok {
+			return empty // not an entity
+		}
+	}
+	ref := false
+	if a != nil {
+		a, ok := a.(int64)
+		if !ok {
+			return empty // not an attribute
+		}
+		if v != nil {
+			attr, ok := s.Attr(a)
+			if ok && !isValueType(s, attr, v) {
+				return empty // values of different types can't be compared
+			}
+			refType, _ := s.Id(schema.DbTypeRef)
+			ref = ok && attr.ValueType == refType
+		}
+	} else {
+		v = nil // values of different attributes can't be compared
+	}
+	switch index := chooseIndex(e != nil, a != nil, v != nil, ref); index {
+	case base.EAVT:
+		var components []any
+		for _, c := range []any{e, a, v} {
+			if c == nil {
+				break
+			}
+			components = append(components, c)
+		}
+		return db.Datoms(index, components...)
+	case base.AEVT:
+		return db.Datoms(index, a)
+	case base.AVET:
+		return db.Datoms(index, a, v)
+	default:
+		return db.Datoms(index, v, a)
+	}
+}
+
+func empty(yield func(base.Datom) bool) {}
+
+// isValueType reports whether v is of the value type of attr
+func isValueType(s schema.Interface, attr schema.Attr, v any) bool {
+	valueType, _ := s.Ident(attr.ValueType)
+	switch v.(type) {
+	case bool:
+		return valueType == schema.DbTypeBoolean
+	case float64:
+		return valueType == schema.DbTypeDouble
+	case string:
+		return valueType == schema.DbTypeString
+	case int64:
+		return valueType == schema.DbTypeLong || valueType == schema.DbTypeRef
+	case symbol.Keyword:
+		return valueType == schema.DbTypeKeyword
+	case time.Time:
+		return valueType == schema.DbTypeInstant
+	default:
+		return false
+	}
+}
+
+func (c *compiler) ruleExpr(sc *scope, expr RuleExpr) (op, error) {
+	rules, ok := c.rules[expr.Name]
+	if !ok {
+		return nil, fmt.Errorf("datoms: unknown rule %v", expr.Name)
+	}
+	if len(rules[0].Vars) != len(expr.Args) {
+		return nil, fmt.Errorf("datoms: rule %v expects %v arguments got %v", expr.Name, len(rules[0].Vars), len(expr.Args))
+	}
+
+	args := make([]term, len(expr.Args))
+	for i, arg := range expr.Args {
+		args[i] = sc.term(arg)
+	}
+
+	sc.bind(collectVars(nil, expr))
+
+	rel, call := c.rel, len(c.calls)
+	c.calls = append(c.calls, expr.Name)
+
+	return func(in iter.Seq[row]) iter.Seq[row] {
+		return func(yield func(row) bool) {
+			r := rel(expr.Name, call)
+			in(func(in row) bool {
+				var (
+					mask uint64
+					vals = make([]any, len(args))
+				)
+				for i, arg := range args {
+					if vals[i] = arg.resolve(in); vals[i] != nil {
+						mask |= 1 << i
+					}
+				}
+				for _, t := range r.lookup(mask, vals) {
+					out := append(row(nil), in...)
+					match := true
+					for i, arg := range args {
+						if match = arg.bind(out, t[i]); !match {
+							break
+						}
+					}
+					if match && !yield(out) {
+						return false
+					}
+				}
+				return true
+			})
+		}
+	}, nil
+}
@@ -0,0 +1,447 @@
+package query
+
+import (
+	"cmp"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/internal/sort"
+	"github.com/leidegre/datoms/iter"
+	"github.com/leidegre/datoms/symbol"
+)
+
+// Func is a function that can be used in predicate and function expressions.
+// A database source argument like $ is passed as a database.Interface.
+type Func func(args ...any) (any, error)
+
+var (
+	funcsLock sync.RWMutex
+	funcs     = map[string]Func{
+		// comparison
+		"=":    eq,
+		"!=":   ne,
+		"not=": ne,
+		"<":    compareFunc("<", func(c int) bool { return c < 0 }),
+		">":    compareFunc(">", func(c int) bool { return c > 0 }),
+		"<=":   compareFunc("<=", func(c int) bool { return c <= 0 }),
+		">=":   compareFunc(">=", func(c int) bool { return c >= 0 }),
+
+		// math
+		"+":    add,
+		"-":    subtract,
+		"*":    multiply,
+		"/":    divide,
+		"quot": integerFunc("quot", func(x, y int64) int64 { return x / y }),
+		"rem":  integerFunc("rem", func(x, y int64) int64 { return x % y }),
+		"mod":  integerFunc("mod", func(x, y int64) int64 { return ((x % y) + y) % y }),
+		"inc":  func(args ...any) (any, error) { return add(append(args, int64(1))...) },
+		"dec":  func(args ...any) (any, error) { return subtract(append(args, int64(1))...) },
+
+		// strings
+		"str":          str,
+		"subs":         subs,
+		"upper-case":   stringFunc("upper-case", strings.ToUpper),
+		"lower-case":   stringFunc("lower-case", strings.ToLower),
+		"trim":         stringFunc("trim", strings.TrimSpace),
+		"starts-with?": stringPredicate("starts-with?", strings.HasPrefix),
+		"ends-with?":   stringPredicate("ends-with?", strings.HasSuffix),
+		"includes?":    stringPredicate("includes?", strings.Contains),
+
+		// misc
+		"identity": identity,
+		"ground":   identity,
+		"missing?": missing,
+		"get-else": getElse,
+	}
+)
+
+// RegisterFunc makes fn available in predicate and function expressions.
+// Safe for concurrent use by multiple goroutines.
+func RegisterFunc(name string, fn Func) {
+	funcsLock.Lock()
+	defer funcsLock.Unlock()
+	funcs[name] = fn
+}
+
+func getFunc(name string) (fn Func, ok bool) {
+	funcsLock.RLock()
+	defer funcsLock.RUnlock()
+	fn, ok = funcs[name]
+	return
+}
+
+// call compiles a function invocation
+func (c *compiler) call(sc *scope, name string, args []any) (func(r row) (any, error), error) {
+	fn, ok := getFunc(name)
+	if !ok {
+		return nil, fmt.Errorf("datoms: unknown function %v", name)
+	}
+
+	terms := make([]term, len(args))
+	for i, arg := range args {
+		switch arg := arg.(type) {
+		case Var:
+			if !sc.isBound(arg) {
+				return nil, fmt.Errorf("datoms: %v argument %v is not bound", name, arg)
+			}
+		case Src:
+			db, err := c.source(arg)
+			if err != nil {
+				return nil, err
+			}
+			terms[i] = term{slot: -1, value: db}
+			continue
+		}
+		terms[i] = sc.term(arg)
+	}
+
+	return func(r row) (any, error) {
+		vals := make([]any, len(terms))
+		for i, t := range terms {
+			vals[i] = t.resolve(r)
+		}
+		return fn(vals...)
+	}, nil
+}
+
+func (c *compiler) predicate(sc *scope, p Predicate) (op, error) {
+	call, err := c.call(sc, p.Fn, p.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(in iter.Seq[row]) iter.Seq[row] {
+		return func(yield func(row) bool) {
+			in(func(r row) bool {
+				v, err := call(r)
+				if err != nil {
+					return c.fail(err)
+				}
+				if v == nil || v == false {
+					return true
+				}
+				return yield(r)
+			})
+		}
+	}, nil
+}
+
+func (c *compiler) function(sc *scope, f Function) (op, error) {
+	call, err := c.call(sc, f.Fn, f.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	bind := sc.term(f.Bind)
+	sc.bind([]Var{f.Bind})
+
+	return func(in iter.Seq[row]) iter.Seq[row] {
+		return func(yield func(row) bool) {
+			in(func(r row) bool {
+				v, err := call(r)
+				if err != nil {
+					return c.fail(err)
+				}
+				if v == nil {
+					return true
+				}
+				out := append(row(nil), r...)
+				if !bind.bind(out, v) {
+					return true
+				}
+				return yield(out)
+			})
+		}
+	}, nil
+}
+
+// isOrdered reports whether v is of a type that has an order
+func isOrdered(v any) bool {
+	switch v.(type) {
+	case bool, float64, string, int64, symbol.Keyword, time.Time:
+		return true
+	default:
+		return false
+	}
+}
+
+// compare compares values of the same type, longs and doubles compare as numbers
+func compare(x, y any) (int, error) {
+	switch x := x.(type) {
+	case int64:
+		if y, ok := y.(float64); ok {
+			return cmp.Compare(float64(x), y), nil
+		}
+	case float64:
+		if y, ok := y.(int64); ok {
+			return cmp.Compare(x, float64(y)), nil
+		}
+	}
+	if !isOrdered(x) || reflect.TypeOf(x) != reflect.TypeOf(y) {
+		return 0, fmt.Errorf("datoms: cannot compare %T and %T", x, y)
+	}
+	return sort.CompareValue(x, y), nil
+}
+
+func eq(args ...any) (any, error) {
+	for i := 1; i < len(args); i++ {
+		if !equal(args[0], args[i]) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func ne(args ...any) (any, error) {
+	v, err := eq(args...)
+	return !v.(bool), err
+}
+
+func compareFunc(name string, test func(c int) bool) Func {
+	return func(args ...any) (any, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("datoms: %v expects at least 1 argument", name)
+		}
+		for i := 1; i < len(args); i++ {
+			c, err := compare(args[i-1], args[i])
+			if err != nil {
+				return nil, err
+			}
+			if !test(c) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+}
+
+// arith folds numbers, the result is a long if all numbers are longs and a double otherwise
+func arith(name string, acc any, args []any, long func(x, y int64) int64, double func(x, y float64) float64) (any, error) {
+	for _, arg := range args {
+		switch y := arg.(type) {
+		case int64:
+			switch x := acc.(type) {
+			case int64:
+				acc = long(x, y)
+			case float64:
+				acc = double(x, float64(y))
+			}
+		case float64:
+			switch x := acc.(type) {
+			case int64:
+				acc = double(float64(x), y)
+			case float64:
+				acc = double(x, y)
+			}
+		default:
+			return nil, fmt.Errorf("datoms: %v expects numbers got %T", name, arg)
+		}
+	}
+	return acc, nil
+}
+
+func add(args ...any) (any, error) {
+	return arith("+", int64(0), args, func(x, y int64) int64 { return x + y }, func(x, y float64) float64 { return x + y })
+}
+
+func multiply(args ...any) (any, error) {
+	return arith("*", int64(1), args, func(x, y int64) int64 { return x * y }, func(x, y float64) float64 { return x * y })
+}
+
+func isNumber(v any) bool {
+	switch v.(type) {
+	case int64, float64:
+		return true
+	default:
+		return false
+	}
+}
+
+// subtract negates a single argument
+func subtract(args ...any) (any, error) {
+	if len(args) == 1 {
+		args = []any{int64(0), args[0]}
+	}
+	if len(args) == 0 || !isNumber(args[0]) {
+		return nil, fmt.Errorf("datoms: - expects numbers")
+	}
+	return arith("-", args[0], args[1:], func(x, y int64) int64 { return x - y }, func(x, y float64) float64 { return x - y })
+}
+
+// divide is always a double, use quot for integer division
+func divide(args ...any) (any, error) {
+	if len(args) == 1 {
+		args = []any{float64(1), args[0]}
+	}
+	if len(args) == 0 || !isNumber(args[0]) {
+		return nil, fmt.Errorf("datoms: / expects numbers")
+	}
+	acc := args[0]
+	if x, ok := acc.(int64); ok {
+		acc = float64(x)
+	}
+	return arith("/", acc, args[1:], nil, func(x, y float64) float64 { return x / y })
+}
+
+func integerFunc(name string, fn func(x, y int64) int64) Func {
+	return func(args ...any) (any, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("datoms: %v expects 2 arguments got %v", name, len(args))
+		}
+		x, ok1 := args[0].(int64)
+		y, ok2 := args[1].(int64)
+		if !(ok1 && ok2) {
+			return nil, fmt.Errorf("datoms: %v expects longs got %T and %T", name, args[0], args[1])
+		}
+		if y == 0 {
+			return nil, fmt.Errorf("datoms: %v divide by zero", name)
+		}
+		return fn(x, y), nil
+	}
+}
+
+func str(args ...any) (any, error) {
+	var sb strings.Builder
+	for _, arg := range args {
+		switch arg := arg.(type) {
+		case nil:
+		case string:
+			sb.WriteString(arg)
+		default:
+			fmt.Fprint(&sb, arg)
+		}
+	}
+	return sb.String(), nil
+}
+
+func stringArgs(name string, args []any, n int) ([]string, error) {
+	if len(args) != n {
+		return nil, fmt.Errorf("datoms: %v expects %v arguments got %v", name, n, len(args))
+	}
+	s := make([]string, n)
+	for i, arg := range args {
+		var ok bool
+		if s[i], ok = arg.(string); !ok {
+			return nil, fmt.Errorf("datoms: %v expects strings got %T", name, arg)
+		}
+	}
+	return s, nil
+}
+
+func stringFunc(name string, fn func(s string) string) Func {
+	return func(args ...any) (any, error) {
+		s, err := stringArgs(name, args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return fn(s[0]), nil
+	}
+}
+
+func stringPredicate(name string, fn func(s, substr string) bool) Func {
+	return func(args ...any) (any, error) {
+		s, err := stringArgs(name, args, 2)
+		if err != nil {
+			return nil, err
+		}
+		return fn(s[0], s[1]), nil
+	}
+}
+
+// subs is the substring from start up to end or the end of the string, the indexes are in runes
+func subs(args ...any) (any, error) {
+	if !(len(args) == 2 || len(args) == 3) {
+		return nil, fmt.Errorf("datoms: subs expects 2 or 3 arguments got %v", len(args))
+	}
+	s, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("datoms: subs expects a string got %T", args[0])
+	}
+	r := []rune(s)
+	bounds := []int64{0, int64(len(r))}
+	for i, arg := range args[1:] {
+		if bounds[i], ok = arg.(int64); !ok {
+			return nil, fmt.Errorf("datoms: subs expects longs got %T", arg)
+		}
+	}
+	start, end := bounds[0], bounds[1]
+	if !(0 <= start && start <= end && end <= int64(len(r))) {
+		return nil, fmt.Errorf("datoms: subs index out of range [%v:%v] with length %v", start, end, len(r))
+	}
+	return string(r[start:end]), nil
+}
+
+func identity(args ...any) (any, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("datoms: expects 1 argument got %v", len(args))
+	}
+	return args[0], nil
+}
+
+// entityAttr is the source, entity and attribute arguments of missing? and get-else
+func entityAttr(name string, args []any) (db database.Interface, e int64, attr schema.Attr, err error) {
+	var ok bool
+	if db, ok = args[0].(database.Interface); !ok {
+		return nil, 0, schema.Attr{}, fmt.Errorf("datoms: %v expects a database got %T", name, args[0])
+	}
+	if e, ok = args[1].(int64); !ok {
+		return nil, 0, schema.Attr{}, fmt.Errorf("datoms: %v expects an entity got %T", name, args[1])
+	}
+	a, err := entid(db.Schema(), args[2])
+	if err != nil {
+		return nil, 0, schema.Attr{}, err
+	}
+	if id, ok := a.(int64); ok {
+		attr, ok = db.Schema().Attr(id)
+	}
+	if !ok {
+		return nil, 0, schema.Attr{}, fmt.Errorf("datoms: %v expects an attribute got %v", name, args[2])
+	}
+	return db, e, attr, nil
+}
+
+// value is the value of the attribute of the entity
+func value(db database.Interface, e int64, attr schema.Attr) (v any, ok bool) {
+	db.Datoms(base.EAVT, e, attr.Id)(func(d base.Datom) bool {
+		v, ok = d.V, true
+		return false
+	})
+	return
+}
+
+// missing is [(missing? $ ?e :attr)]
+func missing(args ...any) (any, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("datoms: missing? expects 3 arguments got %v", len(args))
+	}
+	db, e, attr, err := entityAttr("missing?", args)
+	if err != nil {
+		return nil, err
+	}
+	_, ok := value(db, e, attr)
+	return !ok, nil
+}
+
+// getElse is [(get-else $ ?e :attr default) ?v] for cardinality one attributes
+func getElse(args ...any) (any, error) {
+	if len(args) != 4 {
+		return nil, fmt.Errorf("datoms: get-else expects 4 arguments got %v", len(args))
+	}
+	db, e, attr, err := entityAttr("get-else", args)
+	if err != nil {
+		return nil, err
+	}
+	if many, _ := db.Schema().Id(schema.DbCardinalityMany); attr.Cardinality == many {
+		return nil, fmt.Errorf("datoms: get-else expects a cardinality one attribute got %v", attr.Ident)
+	}
+	if v, ok := value(db, e, attr); ok {
+		return v, nil
+	}
+	return args[3], nil
+}
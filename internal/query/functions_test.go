@@ -0,0 +1,107 @@
+package query_test
+
+import (
+	"testing"
+
+	"github.com/leidegre/datoms/internal/query"
+	"github.com/leidegre/datoms/testutil"
+)
+
+func TestPredicate(t *testing.T) {
+	testutil.AreEqualSlice(t, []string{"ceo", "vp1", "vp2"}, names(t,
+		query.Pattern{query.Var("?e"), personAge, query.Var("?age")},
+		query.Predicate{Fn: ">", Args: []any{query.Var("?age"), 40}},
+	))
+	testutil.AreEqualSlice(t, []string{"eng1", "eng2"}, names(t,
+		query.Pattern{query.Var("?e"), personName, query.Var("?n")},
+		query.Predicate{Fn: "starts-with?", Args: []any{query.Var("?n"), "eng"}},
+	))
+}
+
+func TestFunction(t *testing.T) {
+	db := newDatabase(t)
+
+	result := q(t, query.Query{
+		Find: []query.FindElem{query.Var("?s")},
+		Where: []query.Clause{
+			query.Pattern{query.Var("?e"), personName, "vp1"},
+			query.Pattern{query.Var("?e"), personAge, query.Var("?age")},
+			query.Function{Fn: "quot", Args: []any{query.Var("?age"), 10}, Bind: "?decade"},
+			query.Function{Fn: "upper-case", Args: []any{"vp1"}, Bind: "?name"},
+			query.Function{Fn: "str", Args: []any{query.Var("?name"), " is in their ", query.Var("?decade"), "0s"}, Bind: "?s"},
+		},
+	}, db)
+
+	testutil.AreEqualSlice(t, []string{"VP1 is in their 50s"}, strings(result))
+}
+
+func TestFunctionUnifies(t *testing.T) {
+	// people who are 10 years younger than their manager
+	testutil.AreEqualSlice(t, []string{"vp1"}, names(t,
+		query.Pattern{query.Var("?e"), personManager, query.Var("?m")},
+		query.Pattern{query.Var("?e"), personAge, query.Var("?age")},
+		query.Pattern{query.Var("?m"), personAge, query.Var("?manager-age")},
+		query.Function{Fn: "+", Args: []any{query.Var("?age"), 10}, Bind: "?manager-age"},
+	))
+}
+
+func TestMissingAndGetElse(t *testing.T) {
+	db := newDatabase(t)
+
+	testutil.AreEqualSlice(t, []string{"ceo"}, names(t,
+		query.Pattern{query.Var("?e"), personAge},
+		query.Predicate{Fn: "missing?", Args: []any{query.DefaultSrc, query.Var("?e"), personManager}},
+	))
+
+	result := q(t, query.Query{
+		Find: []query.FindElem{query.Var("?name"), query.Var("?manager")},
+		Where: []query.Clause{
+			query.Pattern{query.Var("?e"), personName, query.Var("?name")},
+			query.Function{Fn: "get-else", Args: []any{query.DefaultSrc, query.Var("?e"), personManager, 0}, Bind: "?manager"},
+		},
+	}, db)
+
+	testutil.AreEqual(t, 5, len(result))
+	testutil.AreEqual(t, "ceo", result[0][0].(string))
+	testutil.AreEqual(t, int64(0), result[0][1].(int64))
+}
+
+func TestRegisterFunc(t *testing.T) {
+	query.RegisterFunc("test/even?", func(args ...any) (any, error) {
+		return args[0].(int64)%2 == 0, nil
+	})
+
+	testutil.AreEqualSlice(t, []string{"ceo", "eng1", "vp1"}, names(t,
+		query.Pattern{query.Var("?e"), personAge, query.Var("?age")},
+		query.Predicate{Fn: "test/even?", Args: []any{query.Var("?age")}},
+	))
+}
+
+func TestFunctionErrors(t *testing.T) {
+	cases := map[string][]query.Clause{
+		"unknown": {
+			query.Pattern{query.Var("?e"), personAge, query.Var("?age")},
+			query.Predicate{Fn: "foo", Args: []any{query.Var("?age")}},
+		},
+		"unbound": {
+			query.Pattern{query.Var("?e"), personAge, query.Var("?age")},
+			query.Predicate{Fn: ">", Args: []any{query.Var("?x"), 40}},
+		},
+		"type": {
+			query.Pattern{query.Var("?e"), personName, query.Var("?name")},
+			query.Predicate{Fn: ">", Args: []any{query.Var("?name"), 40}},
+		},
+	}
+
+	for name, where := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := query.Q(query.Query{
+				Find:  []query.FindElem{query.Var("?e")},
+				Where: where,
+			}, newDatabase(t))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
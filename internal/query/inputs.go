@@ -0,0 +1,191 @@
+package query
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/leidegre/datoms/iter"
+)
+
+// input is the tuples that a binding binds its variables to
+type input struct {
+	vars   []Var
+	tuples [][]any
+}
+
+// bindInput converts the input of a binding that binds variables
+func bindInput(b Binding, in any) (input, error) {
+	switch b := b.(type) {
+	case Var:
+		if in == nil {
+			return input{}, fmt.Errorf("datoms: input %v is nil", b)
+		}
+		return input{[]Var{b}, [][]any{{inputValue(in)}}}, nil
+	case BindTuple:
+		t, err := tuple(b, in, len(b))
+		if err != nil {
+			return input{}, err
+		}
+		return input{b, [][]any{t}}, nil
+	case BindColl:
+		coll, ok := elems(in)
+		if !ok {
+			return input{}, fmt.Errorf("datoms: input [%v ...] is not a slice", Var(b))
+		}
+		tuples := make([][]any, len(coll))
+		for i, v := range coll {
+			tuples[i] = []any{v}
+		}
+		return input{[]Var{Var(b)}, tuples}, nil
+	case BindRel:
+		rel, err := tuples(b, in, len(b))
+		if err != nil {
+			return input{}, err
+		}
+		return input{b, rel}, nil
+	default:
+		return input{}, fmt.Errorf("datoms: unsupported binding %T", b)
+	}
+}
+
+// tuple converts a slice with n elements
+func tuple(b Binding, in any, n int) ([]any, error) {
+	t, ok := elems(in)
+	if !ok {
+		return nil, fmt.Errorf("datoms: input %v is not a slice", b)
+	}
+	if len(t) != n {
+		return nil, fmt.Errorf("datoms: input %v expects tuples of %v values got %v", b, n, len(t))
+	}
+	return t, nil
+}
+
+// tuples converts a slice of slices with n elements each, if n is -1 the
+// first tuple decides
+func tuples(b Binding, in any, n int) ([][]any, error) {
+	rel, ok := elems(in)
+	if !ok {
+		return nil, fmt.Errorf("datoms: input %v is not a slice", b)
+	}
+	tuples := make([][]any, len(rel))
+	for i, t := range rel {
+		if n == -1 {
+			t, ok := elems(t)
+			if !ok {
+				return nil, fmt.Errorf("datoms: input %v is not a slice of slices", b)
+			}
+			n = len(t)
+		}
+		var err error
+		if tuples[i], err = tuple(b, t, n); err != nil {
+			return nil, err
+		}
+	}
+	return tuples, nil
+}
+
+// elems converts the elements of a slice or array
+func elems(in any) ([]any, bool) {
+	rv := reflect.ValueOf(in)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, false
+	}
+	vals := make([]any, rv.Len())
+	for i := range vals {
+		vals[i] = inputValue(rv.Index(i).Interface())
+	}
+	return vals, true
+}
+
+// inputValue converts Go integers to the int64 values of the database
+func inputValue(v any) any {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	default:
+		return v
+	}
+}
+
+// bindInputs binds the variables of the inputs, the ops make a row for every
+// combination of input tuples
+func (e *engine) bindInputs(sc *scope) []op {
+	var ops []op
+	for _, in := range e.input {
+		terms := make([]term, len(in.vars))
+		for i, v := range in.vars {
+			terms[i] = sc.term(v)
+		}
+		sc.bind(in.vars)
+
+		tuples := in.tuples
+		ops = append(ops, func(in iter.Seq[row]) iter.Seq[row] {
+			return func(yield func(row) bool) {
+				in(func(r row) bool {
+					for _, t := range tuples {
+						out := append(row(nil), r...)
+						match := true
+						for i, term := range terms {
+							if match = term.bind(out, t[i]); !match {
+								break
+							}
+						}
+						if match && !yield(out) {
+							return false
+						}
+					}
+					return true
+				})
+			}
+		})
+	}
+	return ops
+}
+
+// relPattern matches a pattern against the tuples of a relation source, the
+// terms of the pattern match the values of a tuple in order
+func (c *compiler) relPattern(sc *scope, src Src, rel *relation, p Pattern) (op, error) {
+	if rel.arity < len(p) && 0 < len(rel.tuples) {
+		return nil, fmt.Errorf("datoms: pattern %v has more terms than source %v has values", p, src)
+	}
+
+	terms := make([]term, len(p))
+	for i, v := range p {
+		terms[i] = sc.term(v)
+	}
+
+	sc.bind(collectVars(nil, p))
+
+	return func(in iter.Seq[row]) iter.Seq[row] {
+		return func(yield func(row) bool) {
+			in(func(r row) bool {
+				var (
+					mask uint64
+					vals = make([]any, max(rel.arity, len(terms)))
+				)
+				for i, t := range terms {
+					if vals[i] = t.resolve(r); vals[i] != nil {
+						mask |= 1 << i
+					}
+				}
+				for _, t := range rel.lookup(mask, vals) {
+					out := append(row(nil), r...)
+					match := true
+					for i, term := range terms {
+						if match = term.bind(out, t[i]); !match {
+							break
+						}
+					}
+					if match && !yield(out) {
+						return false
+					}
+				}
+				return true
+			})
+		}
+	}, nil
+}
@@ -0,0 +1,153 @@
+package query_test
+
+import (
+	"testing"
+
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/internal/query"
+	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/testutil"
+)
+
+func TestScalarInput(t *testing.T) {
+	db := newDatabase(t)
+
+	result := q(t, query.Query{
+		Find: []query.FindElem{query.Var("?age")},
+		In:   []query.Binding{query.DefaultSrc, query.Var("?name")},
+		Where: []query.Clause{
+			query.Pattern{query.Var("?e"), personName, query.Var("?name")},
+			query.Pattern{query.Var("?e"), personAge, query.Var("?age")},
+		},
+	}, db, "vp2")
+
+	testutil.AreEqual(t, 1, len(result))
+	testutil.AreEqual(t, int64(45), result[0][0].(int64))
+}
+
+func TestTupleInput(t *testing.T) {
+	db := newDatabase(t)
+
+	result := q(t, query.Query{
+		Find: []query.FindElem{query.Var("?name")},
+		In:   []query.Binding{query.DefaultSrc, query.BindTuple{query.Var("?manager"), query.Var("?age")}},
+		Where: []query.Clause{
+			query.Pattern{query.Var("?m"), personName, query.Var("?manager")},
+			query.Pattern{query.Var("?e"), personManager, query.Var("?m")},
+			query.Pattern{query.Var("?e"), personAge, query.Var("?age")},
+			query.Pattern{query.Var("?e"), personName, query.Var("?name")},
+		},
+	}, db, []any{"ceo", 45})
+
+	testutil.AreEqualSlice(t, []string{"vp2"}, strings(result))
+}
+
+func TestCollectionInput(t *testing.T) {
+	db := newDatabase(t)
+
+	ids := q(t, query.Query{
+		Find: []query.FindElem{query.Var("?e")},
+		In:   []query.Binding{query.DefaultSrc, query.BindColl("?name")},
+		Where: []query.Clause{
+			query.Pattern{query.Var("?e"), personName, query.Var("?name")},
+		},
+	}, db, []string{"vp1", "eng2", "nobody"})
+
+	testutil.AreEqual(t, 2, len(ids))
+
+	var entities []int64
+	for _, t := range ids {
+		entities = append(entities, t[0].(int64))
+	}
+
+	result := q(t, query.Query{
+		Find: []query.FindElem{query.Var("?name")},
+		In:   []query.Binding{query.DefaultSrc, query.BindColl("?e")},
+		Where: []query.Clause{
+			query.Pattern{query.Var("?e"), personName, query.Var("?name")},
+		},
+	}, db, entities)
+
+	testutil.AreEqualSlice(t, []string{"eng2", "vp1"}, strings(result))
+}
+
+func TestRelationInput(t *testing.T) {
+	db := newDatabase(t)
+
+	result := q(t, query.Query{
+		Find: []query.FindElem{query.Var("?title"), query.Var("?age")},
+		In:   []query.Binding{query.DefaultSrc, query.BindRel{query.Var("?name"), query.Var("?title")}},
+		Where: []query.Clause{
+			query.Pattern{query.Var("?e"), personName, query.Var("?name")},
+			query.Pattern{query.Var("?e"), personAge, query.Var("?age")},
+		},
+	}, db, [][]any{{"ceo", "chief"}, {"eng1", "engineer"}, {"eng2", "engineer"}})
+
+	testutil.AreEqual(t, 3, len(result))
+	testutil.AreEqual(t, "chief", result[0][0].(string))
+	testutil.AreEqual(t, int64(60), result[0][1].(int64))
+	testutil.AreEqual(t, "engineer", result[1][0].(string))
+	testutil.AreEqual(t, int64(25), result[1][1].(int64))
+}
+
+func TestRelationSource(t *testing.T) {
+	db := newDatabase(t)
+
+	result := q(t, query.Query{
+		Find: []query.FindElem{query.Var("?name")},
+		In:   []query.Binding{query.DefaultSrc, query.Src("$titles")},
+		Where: []query.Clause{
+			query.Pattern{query.Src("$titles"), query.Var("?name"), "engineer"},
+			query.Pattern{query.Var("?e"), personName, query.Var("?name")},
+			query.Pattern{query.Var("?e"), personManager, query.Var("?m")},
+			query.Pattern{query.Var("?m"), personName, "vp1"},
+		},
+	}, db, [][]any{{"ceo", "chief"}, {"eng1", "engineer"}, {"eng2", "engineer"}})
+
+	testutil.AreEqualSlice(t, []string{"eng1"}, strings(result))
+}
+
+func TestMultipleSources(t *testing.T) {
+	before := newDatabase(t)
+	after := transact(t, before, database.Add(base.NewTempId(schema.DbPartUser), personName, "eng3")).DbAfter
+
+	result := q(t, query.Query{
+		Find: []query.FindElem{query.Var("?name")},
+		In:   []query.Binding{query.Src("$before"), query.Src("$after")},
+		Where: []query.Clause{
+			query.Pattern{query.Src("$after"), query.Blank, personName, query.Var("?name")},
+			query.Not{Clauses: []query.Clause{
+				query.Pattern{query.Src("$before"), query.Blank, personName, query.Var("?name")},
+			}},
+		},
+	}, before, after)
+
+	testutil.AreEqualSlice(t, []string{"eng3"}, strings(result))
+}
+
+func TestInputErrors(t *testing.T) {
+	db := newDatabase(t)
+
+	cases := []struct {
+		in    query.Binding
+		input any
+	}{
+		{query.Var("?name"), nil},
+		{query.BindTuple{query.Var("?name"), query.Var("?age")}, []any{"ceo"}},
+		{query.BindColl("?name"), "ceo"},
+		{query.BindRel{query.Var("?name"), query.Var("?age")}, []any{"ceo", 60}},
+		{query.Src("$b"), 42},
+	}
+
+	for _, c := range cases {
+		_, err := query.Q(query.Query{
+			Find:  []query.FindElem{query.Var("?e")},
+			In:    []query.Binding{query.DefaultSrc, c.in},
+			Where: []query.Clause{query.Pattern{query.Var("?e"), personName}},
+		}, db, c.input)
+		if err == nil {
+			t.Errorf("expected an error for %v", c.in)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package query
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/internal/sort"
+	"github.com/leidegre/datoms/iter"
+)
+
+// join is a multi-way join of patterns that form a cycle over ref attributes.
+// It's evaluated with a leapfrog triejoin, which binds one variable at a
+// time across all patterns instead of joining the patterns pairwise.
+type join []Pattern
+
+func (join) clause() {}
+
+// isRefPattern reports whether p is [?e :ref/attr ?v] where ?e and ?v are different variables
+func (c *compiler) isRefPattern(p Pattern) bool {
+	src := DefaultSrc
+	if 0 < len(p) {
+		if s, ok := p[0].(Src); ok {
+			src, p = s, p[1:]
+		}
+	}
+	if len(p) != 3 {
+		return false
+	}
+	e, ok1 := p[0].(Var)
+	v, ok2 := p[2].(Var)
+	if !(ok1 && ok2) || e == Blank || v == Blank || e == v {
+		return false
+	}
+	db, err := c.source(src)
+	if err != nil {
+		return false
+	}
+	_, ref := refAttr(db.Schema(), p[1])
+	return ref
+}
+
+// refAttr resolves a constant ref attribute
+func refAttr(s schema.Interface, a any) (int64, bool) {
+	a, err := entid(s, a)
+	if err != nil {
+		return 0, false
+	}
+	id, ok := a.(int64)
+	if !ok {
+		return 0, false
+	}
+	attr, ok := s.Attr(id)
+	refType, _ := s.Id(schema.DbTypeRef)
+	return id, ok && attr.ValueType == refType
+}
+
+// joins finds the ref patterns that form cycles through unbound variables
+// and replaces them with a join
+func (c *compiler) joins(clauses []Clause, isBound func(v Var) bool) []Clause {
+	var (
+		parent = make(map[Var]Var)
+		cyclic = make(map[Var]bool)
+		find   func(v Var) Var
+	)
+	find = func(v Var) Var {
+		if p, ok := parent[v]; ok && p != v {
+			parent[v] = find(p)
+			return parent[v]
+		}
+		parent[v] = v
+		return v
+	}
+
+	var patterns []int
+	for i, clause := range clauses {
+		p, ok := clause.(Pattern)
+		if !ok || !c.isRefPattern(p) {
+			continue
+		}
+		vars := collectVars(nil, p)
+		if isBound(vars[0]) || isBound(vars[1]) {
+			continue
+		}
+		patterns = append(patterns, i)
+		x, y := find(vars[0]), find(vars[1])
+		if x == y {
+			cyclic[x] = true
+		} else {
+			parent[x] = y
+			cyclic[y] = cyclic[y] || cyclic[x]
+		}
+	}
+
+	joins := make(map[Var]join)
+	for _, i := range patterns {
+		p := clauses[i].(Pattern)
+		if root := find(collectVars(nil, p)[0]); cyclic[root] {
+			joins[root] = append(joins[root], p)
+		}
+	}
+	if len(joins) == 0 {
+		return clauses
+	}
+
+	var tmp []Clause
+	for _, clause := range clauses {
+		if p, ok := clause.(Pattern); ok && c.isRefPattern(p) {
+			root := find(collectVars(nil, p)[0])
+			if j, ok := joins[root]; ok {
+				if j != nil {
+					tmp = append(tmp, j)
+					joins[root] = nil
+				}
+				continue
+			}
+		}
+		tmp = append(tmp, clause)
+	}
+	return tmp
+}
+
+// trie is a pattern of a join seen as a trie, the levels are the components
+// of the index after the attribute
+type trie struct {
+	db    database.Interface
+	index base.Index // AEVT or AVET
+	a     int64
+	slots [2]int // slots of the components after the attribute
+}
+
+// seek positions the cursor at the first datom at level that is at or after key,
+// it reports false if there's no such datom
+func (t *trie) seek(c database.Cursor, r row, level int, key any) (int64, bool) {
+	components := []any{t.a}
+	for i := 0; i < level; i++ {
+		components = append(components, r[t.slots[i]])
+	}
+	prefix := sort.TakeWhile(t.index, components)
+	if key != nil {
+		components = append(components, key)
+	}
+	c.Seek(components...)
+	if !c.Valid() || !prefix(c.Key()) {
+		return 0, false
+	}
+	return sort.Component(t.index, c.Key(), level+1).(int64), true
+}
+
+// participant is a trie that takes part at a depth of the join
+type participant struct {
+	trie  int
+	level int
+}
+
+func (c *compiler) join(sc *scope, j join) (op, error) {
+	var (
+		ops   []op // patterns that have both variables bound
+		tries []trie
+		count = make(map[Var]int)
+		vars  []Var // join variables in the order that they are bound
+	)
+
+	for _, p := range j {
+		pvars := collectVars(nil, p)
+		if sc.isBound(pvars[0]) && sc.isBound(pvars[1]) {
+			op, err := c.pattern(sc, p)
+			if err != nil {
+				return nil, err
+			}
+			ops = append(ops, op)
+			continue
+		}
+		for _, v := range pvars {
+			if !sc.isBound(v) {
+				if count[v] == 0 {
+					vars = append(vars, v)
+				}
+				count[v]++
+			}
+		}
+	}
+
+	// the variables that are shared the most go first
+	slices.SortStableFunc(vars, func(x, y Var) int { return cmp.Compare(count[y], count[x]) })
+
+	depth := make(map[Var]int)
+	for i, v := range vars {
+		depth[v] = i
+	}
+	rank := func(v Var) int {
+		if sc.isBound(v) {
+			return -1
+		}
+		return depth[v]
+	}
+
+	participants := make([][]participant, len(vars))
+	for _, p := range j {
+		pvars := collectVars(nil, p)
+		if sc.isBound(pvars[0]) && sc.isBound(pvars[1]) {
+			continue
+		}
+		src, body := DefaultSrc, p
+		if s, ok := p[0].(Src); ok {
+			src, body = s, p[1:]
+		}
+		db, err := c.source(src)
+		if err != nil {
+			return nil, err
+		}
+		a, _ := refAttr(db.Schema(), body[1])
+
+		e, v := pvars[0], pvars[1]
+		t := trie{db: db, index: base.AEVT, a: a}
+		if rank(v) < rank(e) {
+			t.index = base.AVET
+			e, v = v, e
+		}
+		t.slots = [2]int{sc.slot(e), sc.slot(v)}
+
+		for level, v := range []Var{e, v} {
+			if !sc.isBound(v) {
+				participants[depth[v]] = append(participants[depth[v]], participant{len(tries), level})
+			}
+		}
+		tries = append(tries, t)
+	}
+
+	slots := make([]int, len(vars))
+	for i, v := range vars {
+		slots[i] = sc.slot(v)
+	}
+
+	sc.bind(vars)
+
+	return func(in iter.Seq[row]) iter.Seq[row] {
+		for _, op := range ops {
+			in = op(in)
+		}
+		return func(yield func(row) bool) {
+			in(func(r row) bool {
+				cursors := make([]database.Cursor, len(tries))
+				for i, t := range tries {
+					cursors[i] = t.db.Cursor(t.index)
+				}
+				return leapfrog(tries, cursors, participants, slots, append(row(nil), r...), 0, yield)
+			})
+		}
+	}, nil
+}
+
+// leapfrog binds the join variable at depth to every value that all the
+// participants have in common and then moves on to the next depth
+func leapfrog(tries []trie, cursors []database.Cursor, participants [][]participant, slots []int, r row, depth int, yield func(row) bool) bool {
+	if depth == len(slots) {
+		return yield(append(row(nil), r...))
+	}
+
+	ps := participants[depth]
+	keys := make([]int64, len(ps))
+	for i, p := range ps {
+		k, ok := tries[p.trie].seek(cursors[p.trie], r, p.level, nil)
+		if !ok {
+			return true
+		}
+		keys[i] = k
+	}
+
+	// the participant after the one with the largest key moves next
+	order := make([]int, len(ps))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(x, y int) int { return cmp.Compare(keys[x], keys[y]) })
+
+	for i := 0; ; i = (i + 1) % len(order) {
+		var (
+			p  = order[i]
+			hi = keys[order[(i+len(order)-1)%len(order)]]
+			ok bool
+		)
+		if keys[p] == hi {
+			r[slots[depth]] = hi
+			if !leapfrog(tries, cursors, participants, slots, r, depth+1, yield) {
+				return false
+			}
+			r[slots[depth]] = nil
+			keys[p], ok = tries[ps[p].trie].seek(cursors[ps[p].trie], r, ps[p].level, hi+1)
+		} else {
+			keys[p], ok = tries[ps[p].trie].seek(cursors[ps[p].trie], r, ps[p].level, hi)
+		}
+		if !ok {
+			return true
+		}
+	}
+}
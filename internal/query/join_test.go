@@ -0,0 +1,111 @@
+package query_test
+
+import (
+	"testing"
+
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/internal/query"
+	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/symbol"
+	"github.com/leidegre/datoms/testutil"
+)
+
+var personFollows = symbol.For(":person/follows")
+
+func newFollowsDatabase(t *testing.T, follows [][2]string) database.Interface {
+	db := newDatabase(t)
+
+	attr := base.NewTempId(schema.DbPartDb)
+	db = transact(t, db,
+		database.Add(attr, schema.DbIdent, personFollows),
+		database.Add(attr, schema.DbValueType, schema.DbTypeRef),
+		database.Add(attr, schema.DbCardinality, schema.DbCardinalityMany),
+		database.Add(base.Entity{Ident: schema.DbPartDb}, schema.DbInstallAttribute, attr),
+	).DbAfter
+
+	ids := make(map[string]int64)
+	for _, t := range q(t, query.Query{
+		Find:  []query.FindElem{query.Var("?name"), query.Var("?e")},
+		Where: []query.Clause{query.Pattern{query.Var("?e"), personName, query.Var("?name")}},
+	}, db) {
+		ids[t[0].(string)] = t[1].(int64)
+	}
+
+	var txData []base.TxData
+	for _, f := range follows {
+		txData = append(txData, database.Add(base.Entity{Id: ids[f[0]]}, personFollows, ids[f[1]]))
+	}
+	return transact(t, db, txData...).DbAfter
+}
+
+func TestTriangles(t *testing.T) {
+	db := newFollowsDatabase(t, [][2]string{
+		{"ceo", "vp1"}, {"vp1", "vp2"}, {"vp2", "ceo"},
+		{"vp1", "eng1"}, {"eng1", "eng2"}, {"eng2", "vp1"},
+		{"ceo", "eng1"}, {"eng2", "vp2"},
+	})
+
+	triangles := query.Query{
+		Find: []query.FindElem{query.Var("?x"), query.Var("?y"), query.Var("?z")},
+		Where: []query.Clause{
+			query.Pattern{query.Var("?a"), personFollows, query.Var("?b")},
+			query.Pattern{query.Var("?b"), personFollows, query.Var("?c")},
+			query.Pattern{query.Var("?c"), personFollows, query.Var("?a")},
+			query.Pattern{query.Var("?a"), personName, query.Var("?x")},
+			query.Pattern{query.Var("?b"), personName, query.Var("?y")},
+			query.Pattern{query.Var("?c"), personName, query.Var("?z")},
+		},
+	}
+
+	var s []string
+	for _, t := range q(t, triangles, db) {
+		s = append(s, t[0].(string)+" "+t[1].(string)+" "+t[2].(string))
+	}
+
+	// every triangle in every rotation
+	testutil.AreEqualSlice(t, []string{
+		"ceo vp1 vp2",
+		"eng1 eng2 vp1",
+		"eng2 vp1 eng1",
+		"vp1 eng1 eng2",
+		"vp1 vp2 ceo",
+		"vp2 ceo vp1",
+	}, s)
+
+	plan, err := query.Explain(triangles, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log(plan)
+
+	// the follows patterns are joined in one step
+	testutil.AreEqual(t, 4, len(plan.Steps))
+	testutil.AreEqual(t, "LFTJ", plan.Steps[1].Index)
+	testutil.AreEqual(t, int64(6), plan.Steps[1].Rows)
+}
+
+func TestJoinWithBoundVariable(t *testing.T) {
+	db := newFollowsDatabase(t, [][2]string{
+		{"ceo", "vp1"}, {"vp1", "vp2"}, {"vp2", "ceo"},
+		{"vp1", "eng1"}, {"eng1", "eng2"}, {"eng2", "vp1"},
+	})
+
+	// the triangles that eng1 is a part of
+	result := q(t, query.Query{
+		Find: []query.FindElem{query.Var("?y"), query.Var("?z")},
+		Where: []query.Clause{
+			query.Pattern{query.Var("?a"), personName, "eng1"},
+			query.Pattern{query.Var("?a"), personFollows, query.Var("?b")},
+			query.Pattern{query.Var("?b"), personFollows, query.Var("?c")},
+			query.Pattern{query.Var("?c"), personFollows, query.Var("?a")},
+			query.Pattern{query.Var("?b"), personName, query.Var("?y")},
+			query.Pattern{query.Var("?c"), personName, query.Var("?z")},
+		},
+	}, db)
+
+	testutil.AreEqual(t, 1, len(result))
+	testutil.AreEqual(t, "eng2", result[0][0].(string))
+	testutil.AreEqual(t, "vp1", result[0][1].(string))
+}
@@ -0,0 +1,257 @@
+package query
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+	"sync/atomic"
+
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/iter"
+)
+
+// Step is a where clause in the order that it's evaluated
+type Step struct {
+	Clause   Clause
+	Index    string // the index that a pattern scans
+	Estimate int64  // estimated rows for every input row, filters are 0
+	Rows     int64  // rows produced, only set by Explain
+}
+
+// Plan is the order that the where clauses of a query are evaluated in
+type Plan struct {
+	Steps []Step
+}
+
+func (p Plan) String() string {
+	var sb strings.Builder
+	for i, step := range p.Steps {
+		fmt.Fprintf(&sb, "%v. %v", i+1, step.Clause)
+		if step.Index != "" {
+			fmt.Fprintf(&sb, " %v", step.Index)
+		}
+		fmt.Fprintf(&sb, " estimate=%v rows=%v\n", step.Estimate, step.Rows)
+	}
+	return sb.String()
+}
+
+// Explain runs a query like Q and reports the plan that was chosen and the
+// rows that each step produced
+func Explain(q Query, inputs ...any) (Plan, error) {
+	return DefaultPool.Explain(q, inputs...)
+}
+
+// count counts the rows that every op produces
+func (e *engine) count(ops []op) []op {
+	counted := make([]op, len(ops))
+	for i, op := range ops {
+		i, op := i, op
+		counted[i] = func(in iter.Seq[row]) iter.Seq[row] {
+			seq := op(in)
+			return func(yield func(row) bool) {
+				seq(func(r row) bool {
+					atomic.AddInt64(&e.plan[i].Rows, 1)
+					return yield(r)
+				})
+			}
+		}
+	}
+	return counted
+}
+
+// estimates for when there are no statistics
+var defaultStats = database.Stats{Datoms: 1000, Values: 100, Entities: 100}
+
+const (
+	attrsPerEntity = 20      // estimate for [?e ?a ?v] with ?e bound
+	scanAll        = 1 << 30 // estimate for [?e ?a ?v] with nothing bound
+)
+
+// flatten replaces And with its clauses
+func flatten(flat []Clause, clauses []Clause) []Clause {
+	for _, clause := range clauses {
+		if and, ok := clause.(And); ok {
+			flat = flatten(flat, and)
+		} else {
+			flat = append(flat, clause)
+		}
+	}
+	return flat
+}
+
+// plan orders clauses so that the clause with the fewest estimated rows goes
+// first. Filters go as soon as the variables that they need are bound. Ref
+// patterns that form cycles are joined with a leapfrog triejoin.
+func (c *compiler) plan(sc *scope, clauses []Clause) []Step {
+	var (
+		bound     = maps.Clone(sc.bound)
+		isBound   = func(v Var) bool { return bound[v] }
+		remaining = c.joins(flatten(nil, clauses), isBound)
+		steps     []Step
+	)
+
+	for 0 < len(remaining) {
+		best := -1
+		var step Step
+		for i, clause := range remaining {
+			if !ready(clause, isBound) {
+				continue
+			}
+			index, estimate := c.estimate(clause, isBound)
+			if best == -1 || estimate < step.Estimate {
+				best, step = i, Step{Clause: clause, Index: index, Estimate: estimate}
+			}
+		}
+
+		if best == -1 {
+			// nothing can go next, the compiler reports the unbound variables
+			for _, clause := range remaining {
+				steps = append(steps, Step{Clause: clause})
+			}
+			break
+		}
+
+		steps = append(steps, step)
+		for _, v := range binds(step.Clause) {
+			bound[v] = true
+		}
+		remaining = append(remaining[:best], remaining[best+1:]...)
+	}
+
+	return steps
+}
+
+// ready reports whether the variables that clause needs are bound
+func ready(clause Clause, isBound func(v Var) bool) bool {
+	var needs []Var
+	switch clause := clause.(type) {
+	case Not:
+		needs = collectVars(nil, clause)
+	case NotJoin:
+		needs = clause.Vars
+	case Predicate:
+		needs = collectVars(nil, clause)
+	case Function:
+		needs = collectVars(nil, Predicate{clause.Fn, clause.Args})
+	}
+	for _, v := range needs {
+		if !isBound(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// binds is the variables that are bound after clause
+func binds(clause Clause) []Var {
+	switch clause.(type) {
+	case Not, NotJoin, Predicate:
+		return nil
+	default:
+		return collectVars(nil, clause)
+	}
+}
+
+func (c *compiler) estimate(clause Clause, isBound func(v Var) bool) (index string, estimate int64) {
+	switch clause := clause.(type) {
+	case Pattern:
+		return c.estimatePattern(clause, isBound)
+	case join:
+		estimate = scanAll
+		for _, p := range clause {
+			_, e := c.estimatePattern(p, isBound)
+			estimate = min(estimate, e)
+		}
+		return "LFTJ", estimate
+	case Not, NotJoin, Predicate:
+		return "", 0
+	case Function:
+		return "", 1
+	default:
+		// rules and or clauses are cheap when they are invoked with bound variables
+		for _, v := range collectVars(nil, clause) {
+			if isBound(v) {
+				return "", 10
+			}
+		}
+		return "", defaultStats.Datoms
+	}
+}
+
+func (c *compiler) estimatePattern(p Pattern, isBound func(v Var) bool) (index string, estimate int64) {
+	src := DefaultSrc
+	if 0 < len(p) {
+		if s, ok := p[0].(Src); ok {
+			src, p = s, p[1:]
+		}
+	}
+	if rel, ok := c.rels[src]; ok {
+		for _, v := range p {
+			if v, ok := v.(Var); !ok || (v != Blank && isBound(v)) {
+				return "", per(int64(len(rel.tuples)), 10)
+			}
+		}
+		return "", int64(len(rel.tuples))
+	}
+	db, err := c.source(src)
+	if err != nil {
+		return "", 0 // the compiler reports the error
+	}
+
+	bound := func(i int) bool {
+		if len(p) <= i {
+			return false
+		}
+		if v, ok := p[i].(Var); ok {
+			return v != Blank && isBound(v)
+		}
+		return true
+	}
+
+	var (
+		s     = db.Schema()
+		stats = defaultStats
+		ref   bool
+	)
+	if 1 < len(p) {
+		if a, err := entid(s, p[1]); err == nil {
+			if a, ok := a.(int64); ok {
+				if attr, ok := s.Attr(a); ok {
+					refType, _ := s.Id(schema.DbTypeRef)
+					ref = attr.ValueType == refType
+					if statistics, ok := db.(database.Statistics); ok {
+						stats = statistics.Stats(a)
+					}
+				}
+			}
+		}
+	}
+
+	e, a, v := bound(0), bound(1), bound(2)
+
+	switch {
+	case e && a && v:
+		estimate = 1
+	case e && a:
+		estimate = per(stats.Datoms, stats.Entities)
+	case e:
+		estimate = attrsPerEntity
+	case a && v:
+		estimate = per(stats.Datoms, stats.Values)
+	case a:
+		estimate = stats.Datoms
+	default:
+		estimate = scanAll
+	}
+
+	return chooseIndex(e, a, v, ref).String(), estimate
+}
+
+// per is the average rounded up
+func per(n, d int64) int64 {
+	if d == 0 {
+		return 0
+	}
+	return (n + d - 1) / d
+}
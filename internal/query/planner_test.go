@@ -0,0 +1,71 @@
+package query_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/leidegre/datoms/internal/query"
+	"github.com/leidegre/datoms/testutil"
+)
+
+func TestExplain(t *testing.T) {
+	db := newDatabase(t)
+
+	var (
+		name    = query.Pattern{query.Var("?e"), personName, query.Var("?name")}
+		manager = query.Pattern{query.Var("?e"), personManager, query.Var("?m")}
+		ceo     = query.Pattern{query.Var("?m"), personName, "ceo"}
+	)
+
+	plan, err := query.Explain(query.Query{
+		Find:  []query.FindElem{query.Var("?name")},
+		Where: []query.Clause{name, manager, ceo},
+	}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log(plan)
+
+	testutil.AreEqual(t, 3, len(plan.Steps))
+
+	// the ceo is the only pattern that has something bound to begin with
+	testutil.AreEqual(t, "AVET", plan.Steps[0].Index)
+	testutil.AreEqual(t, int64(1), plan.Steps[0].Rows)
+
+	// then the people that the ceo manages
+	testutil.AreEqual(t, "VAET", plan.Steps[1].Index)
+	testutil.AreEqual(t, int64(2), plan.Steps[1].Rows)
+
+	// then their names
+	testutil.AreEqual(t, "EAVT", plan.Steps[2].Index)
+	testutil.AreEqual(t, "EAVT", plan.Steps[2].Index)
+	testutil.AreEqual(t, int64(2), plan.Steps[2].Rows)
+}
+
+func TestExplainFilters(t *testing.T) {
+	db := newDatabase(t)
+
+	plan, err := query.Explain(query.Query{
+		Find: []query.FindElem{query.Var("?name")},
+		Where: []query.Clause{
+			query.Predicate{Fn: "<", Args: []any{query.Var("?age"), 40}},
+			query.Pattern{query.Var("?e"), personAge, query.Var("?age")},
+			query.Pattern{query.Var("?e"), personName, query.Var("?name")},
+		},
+	}, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log(plan)
+
+	// the predicate goes right after ?age is bound
+	testutil.AreEqual(t, 3, len(plan.Steps))
+	testutil.AreEqual(t, "AEVT", plan.Steps[0].Index)
+	testutil.AreEqual(t, int64(5), plan.Steps[0].Rows)
+	testutil.AreEqual(t, "query.Predicate", fmt.Sprintf("%T", plan.Steps[1].Clause))
+	testutil.AreEqual(t, int64(2), plan.Steps[1].Rows)
+	testutil.AreEqual(t, "EAVT", plan.Steps[2].Index)
+	testutil.AreEqual(t, int64(2), plan.Steps[2].Rows)
+}
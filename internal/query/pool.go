@@ -0,0 +1,202 @@
+package query
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/leidegre/datoms/iter"
+)
+
+// Pool limits the number of goroutines that queries evaluate on. Database
+// values are immutable so the rows of a query can be evaluated in parallel,
+// a pool can be shared by any number of queries.
+type Pool struct {
+	sem chan struct{}
+}
+
+// NewPool makes a pool with a number of workers, a pool with a single worker
+// evaluates queries on the calling goroutine
+func NewPool(workers int) *Pool {
+	return &Pool{sem: make(chan struct{}, max(1, workers))}
+}
+
+// DefaultPool is the pool that Q and Explain use, it has a worker for every CPU
+var DefaultPool = NewPool(runtime.GOMAXPROCS(0))
+
+// Q runs a query using the workers of the pool
+func (p *Pool) Q(q Query, inputs ...any) ([][]any, error) {
+	e, err := newEngine(p, q.In, inputs)
+	if err != nil {
+		return nil, err
+	}
+	return e.run(q)
+}
+
+// Seq runs a query lazily using the workers of the pool
+func (p *Pool) Seq(q Query, inputs ...any) iter.Seq2[[]any, error] {
+	return func(yield func([]any, error) bool) {
+		e, err := newEngine(p, q.In, inputs)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		find, rows, err := e.prepare(q)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		if find.aggregates != nil {
+			// aggregates need every row before they produce anything
+			result, err := e.collect(find, rows)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, t := range result {
+				if !yield(t, nil) {
+					return
+				}
+			}
+			return
+		}
+		var (
+			seen    = make(map[string]bool)
+			stopped bool
+		)
+		rows(func(r row) bool {
+			t := find.tuple(r)
+			if k := tupleKey(t); !seen[k] {
+				seen[k] = true
+				stopped = !yield(t, nil)
+			}
+			return !stopped
+		})
+		if !stopped && e.err != nil {
+			yield(nil, e.err)
+		}
+	}
+}
+
+// Explain explains a query using the workers of the pool
+func (p *Pool) Explain(q Query, inputs ...any) (Plan, error) {
+	e, err := newEngine(p, q.In, inputs)
+	if err != nil {
+		return Plan{}, err
+	}
+	e.explain = true
+	if _, err := e.run(q); err != nil {
+		return Plan{}, err
+	}
+	return Plan{Steps: e.plan}, nil
+}
+
+func (p *Pool) serial() bool { return cap(p.sem) == 1 }
+
+// run runs the tasks and waits for them to finish
+func (p *Pool) run(tasks []func()) {
+	if p.serial() {
+		for _, task := range tasks {
+			task()
+		}
+		return
+	}
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		task := task
+		p.sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-p.sem
+				wg.Done()
+			}()
+			task()
+		}()
+	}
+	wg.Wait()
+}
+
+// batchSize is the number of rows that a worker evaluates at a time
+const batchSize = 256
+
+// pipeline evaluates ops for batches of rows from in on the workers of the
+// pool. The rows come out in no particular order.
+func (p *Pool) pipeline(in iter.Seq[row], ops []op) iter.Seq[row] {
+	if p.serial() || len(ops) == 0 {
+		for _, op := range ops {
+			in = op(in)
+		}
+		return in
+	}
+
+	return func(yield func(row) bool) {
+		var (
+			results = make(chan []row)
+			done    = make(chan struct{})
+			wg      sync.WaitGroup
+		)
+
+		submit := func(batch []row) bool {
+			select {
+			case <-done:
+				return false
+			default:
+			}
+			select {
+			case p.sem <- struct{}{}:
+			case <-done:
+				return false
+			}
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-p.sem
+					wg.Done()
+				}()
+				var out []row
+				for _, r := range batch {
+					from(r, ops)(func(r row) bool {
+						out = append(out, r)
+						return true
+					})
+				}
+				select {
+				case results <- out:
+				case <-done:
+				}
+			}()
+			return true
+		}
+
+		go func() {
+			var batch []row
+			in(func(r row) bool {
+				batch = append(batch, r)
+				if len(batch) < batchSize {
+					return true
+				}
+				b := batch
+				batch = nil
+				return submit(b)
+			})
+			if 0 < len(batch) {
+				submit(batch)
+			}
+			wg.Wait()
+			close(results)
+		}()
+
+		stopped := false
+		for out := range results {
+			for _, r := range out {
+				if stopped {
+					break
+				}
+				if !yield(r) {
+					stopped = true
+					close(done)
+				}
+			}
+		}
+	}
+}
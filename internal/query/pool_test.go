@@ -0,0 +1,93 @@
+package query_test
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/internal/query"
+	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/testutil"
+)
+
+// newTreeDatabase has n people where person i is managed by person i/2
+func newTreeDatabase(t *testing.T, n int) database.Interface {
+	db := newDatabase(t)
+
+	tempIds := make([]base.TempId, n)
+	for i := range tempIds {
+		tempIds[i] = base.NewTempId(schema.DbPartUser)
+	}
+
+	var txData []base.TxData
+	for i := range tempIds {
+		txData = append(txData, database.Add(tempIds[i], personName, fmt.Sprintf("tree%v", i)))
+		txData = append(txData, database.Add(tempIds[i], personAge, int64(i%100)))
+		if 0 < i {
+			txData = append(txData, database.Add(tempIds[i], personManager, tempIds[i/2]))
+		}
+	}
+	return transact(t, db, txData...).DbAfter
+}
+
+func TestPool(t *testing.T) {
+	db := newTreeDatabase(t, 2000)
+
+	queries := []struct {
+		q      query.Query
+		inputs []any
+	}{
+		{
+			query.Query{
+				Find: []query.FindElem{query.Var("?name"), query.Var("?manager")},
+				Where: []query.Clause{
+					query.Pattern{query.Var("?e"), personManager, query.Var("?m")},
+					query.Pattern{query.Var("?e"), personName, query.Var("?name")},
+					query.Pattern{query.Var("?m"), personName, query.Var("?manager")},
+					query.Pattern{query.Var("?m"), personAge, query.Var("?age")},
+					query.Predicate{Fn: "<", Args: []any{query.Var("?age"), 50}},
+				},
+			},
+			[]any{db},
+		},
+		{
+			query.Query{
+				Find: []query.FindElem{query.Aggregate{Fn: "count", Args: []any{query.Var("?e")}}},
+				In:   []query.Binding{query.DefaultSrc, query.Rules},
+				Where: []query.Clause{
+					query.Pattern{query.Var("?m"), personName, "tree1"},
+					query.RuleExpr{Name: "reports-to", Args: []any{query.Var("?e"), query.Var("?m")}},
+				},
+			},
+			[]any{db, rules},
+		},
+	}
+
+	serial, parallel := query.NewPool(1), query.NewPool(4)
+
+	for i, c := range queries {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			expected, err := serial.Q(c.q, c.inputs...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual, err := parallel.Q(c.q, c.inputs...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.AreEqual(t, true, 0 < len(expected))
+			testutil.AreEqualSlice(t, sorted(expected), sorted(actual))
+		})
+	}
+}
+
+func sorted(result [][]any) []string {
+	var s []string
+	for _, t := range result {
+		s = append(s, fmt.Sprint(t))
+	}
+	slices.Sort(s)
+	return s
+}
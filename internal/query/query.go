@@ -0,0 +1,167 @@
+// Datalog queries over database values
+package query
+
+import "github.com/leidegre/datoms/iter"
+
+// Var is a logic variable like ?e. The blank variable _ matches anything and binds nothing.
+type Var string
+
+const Blank Var = "_"
+
+// Src names a database input like $, $a or $b
+type Src string
+
+const DefaultSrc Src = "$"
+
+// RulesVar names the rules input %, the input is a []Rule
+type RulesVar string
+
+const Rules RulesVar = "%"
+
+// Binding is anything that can go in :in
+type Binding interface {
+	binding()
+}
+
+// FindElem is anything that can go in :find, a Var or an Aggregate
+type FindElem interface {
+	findElem()
+}
+
+func (Var) findElem() {}
+
+// BindTuple binds a tuple input like [?a ?b], the input is a slice
+type BindTuple []Var
+
+// BindColl binds every element of a collection input like [?x ...], the input is a slice
+type BindColl Var
+
+// BindRel binds every tuple of a relation input like [[?a ?b]], the input is a slice of slices
+type BindRel []Var
+
+func (Var) binding()       {}
+func (Src) binding()       {}
+func (RulesVar) binding()  {}
+func (BindTuple) binding() {}
+func (BindColl) binding()  {}
+func (BindRel) binding()   {}
+
+// Clause is anything that can go in :where
+type Clause interface {
+	clause()
+}
+
+// Pattern is a data pattern like [?e :person/name ?name] or [$ ?e :person/name ?name ?tx].
+// The terms are E, A, V and T in that order, trailing terms can be omitted.
+type Pattern []any
+
+func (Pattern) clause() {}
+
+// RuleExpr invokes a rule like (ancestor ?a ?b)
+type RuleExpr struct {
+	Name string
+	Args []any
+}
+
+func (RuleExpr) clause() {}
+
+// Not removes the rows that match its clauses like (not [?c :order/customer ?e]).
+// Every variable in the clauses must already be bound.
+type Not struct {
+	Clauses []Clause
+}
+
+func (Not) clause() {}
+
+// NotJoin is like Not but only joins on Vars, which must already be bound.
+// Any other variable is local to the clause.
+type NotJoin struct {
+	Vars    []Var
+	Clauses []Clause
+}
+
+func (NotJoin) clause() {}
+
+// Or matches if any of its clauses match, use And to group clauses. Every
+// clause must use the same variables.
+type Or struct {
+	Clauses []Clause
+}
+
+func (Or) clause() {}
+
+// OrJoin is like Or but only joins on Vars, every clause must bind them.
+// Any other variable is local to the clause.
+type OrJoin struct {
+	Vars    []Var
+	Clauses []Clause
+}
+
+func (OrJoin) clause() {}
+
+// And groups clauses within Or and OrJoin like (and [?e :person/age ?age] [(< ?age 18)])
+type And []Clause
+
+func (And) clause() {}
+
+// Predicate filters rows like [(> ?age 21)]. Fn is the name of a registered
+// function, the row matches unless the function returns nil or false.
+// Variables in Args must already be bound.
+type Predicate struct {
+	Fn   string
+	Args []any
+}
+
+func (Predicate) clause() {}
+
+// Function binds the result of a registered function like [(str ?first " " ?last) ?name].
+// Variables in Args must already be bound. If the function returns nil the row is removed.
+type Function struct {
+	Fn   string
+	Args []any
+	Bind Var
+}
+
+func (Function) clause() {}
+
+// Rule is a named rule. Rules that share a name are alternatives, if any of
+// them match the rule matches. A rule can invoke itself.
+//
+//	[(ancestor ?a ?b) [?a :person/parent ?b]]
+//	[(ancestor ?a ?b) [?a :person/parent ?x] (ancestor ?x ?b)]
+type Rule struct {
+	Name string
+	Vars []Var
+	Body []Clause
+}
+
+// Aggregate is an aggregate expression like (count ?e) or (min 3 ?age). The
+// last argument is the aggregated variable, any preceding arguments are
+// constants. Find variables that aren't aggregated group the result.
+type Aggregate struct {
+	Fn   string
+	Args []any
+}
+
+func (Aggregate) findElem() {}
+
+type Query struct {
+	Find  []FindElem
+	With  []Var     // variables that are considered when aggregating but not returned
+	In    []Binding // defaults to $
+	Where []Clause
+}
+
+// Q runs a query. The inputs are bound to :in in order, database sources
+// must be a database.Interface or a relation like [][]any and the rules
+// input % must be a []Rule. A Var binds a scalar input.
+func Q(q Query, inputs ...any) ([][]any, error) {
+	return DefaultPool.Q(q, inputs...)
+}
+
+// Seq runs a query like Q but produces the result lazily, evaluation stops
+// when the caller stops. Rules are evaluated up front and so are queries
+// with aggregates. If the query fails the error is the last thing produced.
+func Seq(q Query, inputs ...any) iter.Seq2[[]any, error] {
+	return DefaultPool.Seq(q, inputs...)
+}
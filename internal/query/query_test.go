@@ -0,0 +1,268 @@
+package query_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/internal/query"
+	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/internal/sort"
+	"github.com/leidegre/datoms/storage/mem"
+	"github.com/leidegre/datoms/symbol"
+	"github.com/leidegre/datoms/testutil"
+)
+
+var (
+	personName    = symbol.For(":person/name")
+	personAge     = symbol.For(":person/age")
+	personManager = symbol.For(":person/manager")
+)
+
+func transact(t *testing.T, db database.Interface, txData ...base.TxData) database.Transaction {
+	tx, err := db.With(txData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func attribute(ident, valueType symbol.Keyword) []base.TxData {
+	attr := base.NewTempId(schema.DbPartDb)
+	return []base.TxData{
+		database.Add(attr, schema.DbIdent, ident),
+		database.Add(attr, schema.DbValueType, valueType),
+		database.Add(attr, schema.DbCardinality, schema.DbCardinalityOne),
+		database.Add(base.Entity{Ident: schema.DbPartDb}, schema.DbInstallAttribute, attr),
+	}
+}
+
+type person struct {
+	name    string
+	age     int64
+	manager string
+}
+
+// The org chart looks like this
+//
+//	ceo
+//	├── vp1
+//	│   └── eng1
+//	│       └── eng2
+//	└── vp2
+var people = []person{
+	{"ceo", 60, ""},
+	{"vp1", 50, "ceo"},
+	{"vp2", 45, "ceo"},
+	{"eng1", 30, "vp1"},
+	{"eng2", 25, "eng1"},
+}
+
+func newDatabase(t *testing.T) database.Interface {
+	var txData []base.TxData
+	txData = append(txData, attribute(personName, schema.DbTypeString)...)
+	txData = append(txData, attribute(personAge, schema.DbTypeLong)...)
+	txData = append(txData, attribute(personManager, schema.DbTypeRef)...)
+
+	db := transact(t, mem.New(), txData...).DbAfter
+
+	tempIds := make(map[string]base.TempId)
+	for _, p := range people {
+		tempIds[p.name] = base.NewTempId(schema.DbPartUser)
+	}
+
+	txData = nil
+	for _, p := range people {
+		txData = append(txData, database.Add(tempIds[p.name], personName, p.name))
+		txData = append(txData, database.Add(tempIds[p.name], personAge, p.age))
+		if p.manager != "" {
+			txData = append(txData, database.Add(tempIds[p.name], personManager, tempIds[p.manager]))
+		}
+	}
+
+	return transact(t, db, txData...).DbAfter
+}
+
+func q(t *testing.T, q query.Query, inputs ...any) [][]any {
+	result, err := query.Q(q, inputs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(result, func(x, y []any) int {
+		for i := range x {
+			if c := sort.CompareValue(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+	return result
+}
+
+func strings(result [][]any) []string {
+	var s []string
+	for _, t := range result {
+		s = append(s, t[0].(string))
+	}
+	return s
+}
+
+func TestPattern(t *testing.T) {
+	db := newDatabase(t)
+
+	result := q(t, query.Query{
+		Find: []query.FindElem{query.Var("?name")},
+		Where: []query.Clause{
+			query.Pattern{query.Var("?e"), personManager, query.Var("?m")},
+			query.Pattern{query.Var("?m"), personName, "ceo"},
+			query.Pattern{query.Var("?e"), personName, query.Var("?name")},
+		},
+	}, db)
+
+	testutil.AreEqualSlice(t, []string{"vp1", "vp2"}, strings(result))
+}
+
+func TestPatternMismatchedTypes(t *testing.T) {
+	db := newDatabase(t)
+
+	result := q(t, query.Query{
+		Find: []query.FindElem{query.Var("?e")},
+		Where: []query.Clause{
+			query.Pattern{query.Var("?e"), personAge, query.Var("?x")},
+			query.Pattern{query.Var("?e"), personName, query.Var("?x")},
+		},
+	}, db)
+
+	testutil.AreEqual(t, 0, len(result))
+}
+
+var rules = []query.Rule{
+	{
+		Name: "reports-to",
+		Vars: []query.Var{"?e", "?m"},
+		Body: []query.Clause{
+			query.Pattern{query.Var("?e"), personManager, query.Var("?m")},
+		},
+	},
+	{
+		Name: "reports-to",
+		Vars: []query.Var{"?e", "?m"},
+		Body: []query.Clause{
+			query.Pattern{query.Var("?e"), personManager, query.Var("?x")},
+			query.RuleExpr{Name: "reports-to", Args: []any{query.Var("?x"), query.Var("?m")}},
+		},
+	},
+}
+
+func TestRecursiveRule(t *testing.T) {
+	db := newDatabase(t)
+
+	reportsTo := func(manager string) []string {
+		return strings(q(t, query.Query{
+			Find: []query.FindElem{query.Var("?name")},
+			In:   []query.Binding{query.DefaultSrc, query.Rules},
+			Where: []query.Clause{
+				query.Pattern{query.Var("?m"), personName, manager},
+				query.RuleExpr{Name: "reports-to", Args: []any{query.Var("?e"), query.Var("?m")}},
+				query.Pattern{query.Var("?e"), personName, query.Var("?name")},
+			},
+		}, db, rules))
+	}
+
+	testutil.AreEqualSlice(t, []string{"eng1", "eng2", "vp1", "vp2"}, reportsTo("ceo"))
+	testutil.AreEqualSlice(t, []string{"eng1", "eng2"}, reportsTo("vp1"))
+	testutil.AreEqualSlice(t, []string{"eng2"}, reportsTo("eng1"))
+	testutil.AreEqualSlice(t, []string(nil), reportsTo("vp2"))
+}
+
+func TestRecursiveRulePlanned(t *testing.T) {
+	db := newDatabase(t)
+
+	// the recursive invocation comes first in the body but is planned last
+	rules := []query.Rule{
+		{
+			Name: "boss",
+			Vars: []query.Var{"?a", "?b"},
+			Body: []query.Clause{query.Pattern{query.Var("?a"), personManager, query.Var("?b")}},
+		},
+		{
+			Name: "above",
+			Vars: []query.Var{"?a", "?b"},
+			Body: []query.Clause{query.RuleExpr{Name: "boss", Args: []any{query.Var("?a"), query.Var("?b")}}},
+		},
+		{
+			Name: "above",
+			Vars: []query.Var{"?a", "?b"},
+			Body: []query.Clause{
+				query.RuleExpr{Name: "above", Args: []any{query.Var("?x"), query.Var("?b")}},
+				query.RuleExpr{Name: "boss", Args: []any{query.Var("?a"), query.Var("?x")}},
+				query.Pattern{query.Var("?a"), personName, "eng2"},
+			},
+		},
+	}
+
+	result := q(t, query.Query{
+		Find: []query.FindElem{query.Var("?name")},
+		In:   []query.Binding{query.DefaultSrc, query.Rules},
+		Where: []query.Clause{
+			query.Pattern{query.Var("?e"), personName, "eng2"},
+			query.RuleExpr{Name: "above", Args: []any{query.Var("?e"), query.Var("?m")}},
+			query.Pattern{query.Var("?m"), personName, query.Var("?name")},
+		},
+	}, db, rules)
+
+	testutil.AreEqualSlice(t, []string{"eng1", "vp1"}, strings(result))
+}
+
+func TestMutuallyRecursiveRules(t *testing.T) {
+	db := newDatabase(t)
+
+	// even and odd distance from the ceo
+	rules := []query.Rule{
+		{
+			Name: "even",
+			Vars: []query.Var{"?e"},
+			Body: []query.Clause{query.Pattern{query.Var("?e"), personName, "ceo"}},
+		},
+		{
+			Name: "even",
+			Vars: []query.Var{"?e"},
+			Body: []query.Clause{
+				query.Pattern{query.Var("?e"), personManager, query.Var("?m")},
+				query.RuleExpr{Name: "odd", Args: []any{query.Var("?m")}},
+			},
+		},
+		{
+			Name: "odd",
+			Vars: []query.Var{"?e"},
+			Body: []query.Clause{
+				query.Pattern{query.Var("?e"), personManager, query.Var("?m")},
+				query.RuleExpr{Name: "even", Args: []any{query.Var("?m")}},
+			},
+		},
+	}
+
+	result := q(t, query.Query{
+		Find: []query.FindElem{query.Var("?name")},
+		In:   []query.Binding{query.DefaultSrc, query.Rules},
+		Where: []query.Clause{
+			query.RuleExpr{Name: "odd", Args: []any{query.Var("?e")}},
+			query.Pattern{query.Var("?e"), personName, query.Var("?name")},
+		},
+	}, db, rules)
+
+	testutil.AreEqualSlice(t, []string{"eng2", "vp1", "vp2"}, strings(result))
+}
+
+func TestUnknownRule(t *testing.T) {
+	_, err := query.Q(query.Query{
+		Find:  []query.FindElem{query.Var("?e")},
+		In:    []query.Binding{query.DefaultSrc, query.Rules},
+		Where: []query.Clause{query.RuleExpr{Name: "foo", Args: []any{query.Var("?e")}}},
+	}, newDatabase(t), rules)
+
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,103 @@
+package query
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/leidegre/datoms/internal/base"
+)
+
+// appendKey appends an encoding of v that can be used to tell values apart,
+// query values can be nil and of types other than the value types
+func appendKey(b []byte, v any) []byte {
+	if b, ok := base.AppendValue(b, v); ok {
+		return b
+	}
+	if v == nil {
+		return append(b, '_')
+	}
+	s := fmt.Sprintf("%T:%#v", v, v)
+	b = append(b, '?')
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func tupleKey(t []any) string {
+	var b []byte
+	for _, v := range t {
+		b = appendKey(b, v)
+	}
+	return string(b)
+}
+
+// equal compares values for equality, instants are equal if they are the same point in time
+func equal(x, y any) bool {
+	if x, ok := x.(time.Time); ok {
+		y, ok := y.(time.Time)
+		return ok && x.Equal(y)
+	}
+	return x == y
+}
+
+// relation is a set of tuples of the same arity
+type relation struct {
+	arity  int
+	tuples [][]any
+	keys   map[string]bool
+	mu     sync.Mutex                    // lookup builds the index while the relation is being read
+	index  map[uint64]map[string][][]any // bound positions -> key -> tuples
+}
+
+func newRelation(arity int) *relation {
+	return &relation{arity: arity, keys: make(map[string]bool)}
+}
+
+// add adds the tuple unless it's already in the relation
+func (r *relation) add(t []any) bool {
+	k := tupleKey(t)
+	if r.keys[k] {
+		return false
+	}
+	r.keys[k] = true
+	r.tuples = append(r.tuples, t)
+	r.index = nil
+	return true
+}
+
+func (r *relation) has(t []any) bool {
+	return r.keys[tupleKey(t)]
+}
+
+// lookup finds the tuples that have the values at the positions in mask
+func (r *relation) lookup(mask uint64, vals []any) [][]any {
+	if mask == 0 {
+		return r.tuples
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.index == nil {
+		r.index = make(map[uint64]map[string][][]any)
+	}
+	idx, ok := r.index[mask]
+	if !ok {
+		idx = make(map[string][][]any)
+		for _, t := range r.tuples {
+			k := maskedKey(mask, t)
+			idx[k] = append(idx[k], t)
+		}
+		r.index[mask] = idx
+	}
+	return idx[maskedKey(mask, vals)]
+}
+
+func maskedKey(mask uint64, t []any) string {
+	var b []byte
+	for i, v := range t {
+		if mask&(1<<i) != 0 {
+			b = appendKey(b, v)
+		}
+	}
+	return string(b)
+}
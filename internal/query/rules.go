@@ -0,0 +1,242 @@
+package query
+
+import (
+	"fmt"
+)
+
+// call is a rule invocation
+type call struct {
+	name    string
+	negated bool
+}
+
+// ruleCalls finds the rules that clauses invoke in the order of the clauses,
+// the planner can compile them in another order
+func ruleCalls(calls []call, clauses []Clause, negated bool) []call {
+	for _, clause := range clauses {
+		switch clause := clause.(type) {
+		case RuleExpr:
+			calls = append(calls, call{clause.Name, negated})
+		case Not:
+			calls = ruleCalls(calls, clause.Clauses, true)
+		case NotJoin:
+			calls = ruleCalls(calls, clause.Clauses, true)
+		case Or:
+			calls = ruleCalls(calls, clause.Clauses, negated)
+		case OrJoin:
+			calls = ruleCalls(calls, clause.Clauses, negated)
+		case And:
+			calls = ruleCalls(calls, clause, negated)
+		}
+	}
+	return calls
+}
+
+// strata orders the rules reachable from clauses so that a rule comes after
+// the rules that it depends on. Mutually recursive rules end up in the same
+// stratum. This is Tarjan's strongly connected components algorithm.
+func (e *engine) strata(clauses []Clause) [][]string {
+	var (
+		index   = make(map[string]int)
+		lowlink = make(map[string]int)
+		onStack = make(map[string]bool)
+		stack   []string
+		strata  [][]string
+		visit   func(name string)
+	)
+
+	visit = func(name string) {
+		index[name] = len(index)
+		lowlink[name] = index[name]
+		stack = append(stack, name)
+		onStack[name] = true
+
+		var deps []call
+		for _, rule := range e.rules[name] {
+			deps = ruleCalls(deps, rule.Body, false)
+		}
+
+		for _, dep := range deps {
+			dep := dep.name
+			if _, ok := index[dep]; !ok {
+				visit(dep)
+				lowlink[name] = min(lowlink[name], lowlink[dep])
+			} else if onStack[dep] {
+				lowlink[name] = min(lowlink[name], index[dep])
+			}
+		}
+
+		if lowlink[name] == index[name] {
+			var stratum []string
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				stratum = append(stratum, top)
+				if top == name {
+					break
+				}
+			}
+			strata = append(strata, stratum)
+		}
+	}
+
+	for _, call := range ruleCalls(nil, clauses, false) {
+		if _, ok := index[call.name]; !ok {
+			visit(call.name)
+		}
+	}
+
+	return strata
+}
+
+// variant is a compiled rule body. A rule body that invokes recursive rules
+// is compiled once for every such invocation, in each variant one of them
+// reads the delta of the last iteration (semi-naive evaluation).
+type variant struct {
+	name string
+	n    int   // slots
+	head []int // slots of rule vars
+	ops  []op
+}
+
+// compileRule compiles a rule body, calls are the rules that it invokes in
+// the order that rel is asked for them
+func (e *engine) compileRule(rule Rule, rel func(name string, call int) *relation) (v variant, calls []string, err error) {
+	sc := newScope()
+
+	c := &compiler{engine: e, rel: rel}
+	ops, err := c.compile(sc, rule.Body)
+	if err != nil {
+		return variant{}, nil, err
+	}
+
+	head := make([]int, len(rule.Vars))
+	for i, v := range rule.Vars {
+		if !sc.isBound(v) {
+			return variant{}, nil, fmt.Errorf("datoms: rule %v variable %v is not bound", rule.Name, v)
+		}
+		head[i] = sc.slot(v)
+	}
+
+	return variant{rule.Name, *sc.n, head, ops}, c.calls, nil
+}
+
+// eval evaluates the rule body
+func (v variant) eval() [][]any {
+	var tuples [][]any
+	from(make(row, v.n), v.ops)(func(r row) bool {
+		t := make([]any, len(v.head))
+		for i, slot := range v.head {
+			t[i] = r[slot]
+		}
+		tuples = append(tuples, t)
+		return true
+	})
+	return tuples
+}
+
+// evalVariants evaluates the variants on the workers of the pool and adds the
+// tuples that are not in the full relations to next
+func (e *engine) evalVariants(variants []variant, next map[string]*relation) error {
+	var (
+		tuples = make([][][]any, len(variants))
+		tasks  = make([]func(), len(variants))
+	)
+	for i, v := range variants {
+		i, v := i, v
+		tasks[i] = func() { tuples[i] = v.eval() }
+	}
+	e.pool.run(tasks)
+	if e.err != nil {
+		return e.err
+	}
+	for i, v := range variants {
+		for _, t := range tuples[i] {
+			if !e.full[v.name].has(t) {
+				next[v.name].add(t)
+			}
+		}
+	}
+	return nil
+}
+
+// evalRules computes the relations of all rules that clauses depend on
+func (e *engine) evalRules(clauses []Clause) error {
+	for _, stratum := range e.strata(clauses) {
+		recursive := make(map[string]bool)
+		for _, name := range stratum {
+			if _, ok := e.rules[name]; !ok {
+				return fmt.Errorf("datoms: unknown rule %v", name)
+			}
+			recursive[name] = true
+			e.full[name] = newRelation(len(e.rules[name][0].Vars))
+		}
+
+		var (
+			initial []variant
+			deltas  []variant
+		)
+
+		for _, name := range stratum {
+			for _, rule := range e.rules[name] {
+				for _, callee := range ruleCalls(nil, rule.Body, false) {
+					if recursive[callee.name] && callee.negated {
+						return fmt.Errorf("datoms: rule %v depends on the negation of itself", name)
+					}
+				}
+
+				v, calls, err := e.compileRule(rule, e.relations)
+				if err != nil {
+					return err
+				}
+				initial = append(initial, v)
+
+				// one variant for every invocation of a rule in this stratum,
+				// the body is planned the same way every time it's compiled
+				for i, callee := range calls {
+					if !recursive[callee] {
+						continue
+					}
+					i := i
+					v, _, err := e.compileRule(rule, func(name string, call int) *relation {
+						if call == i {
+							return e.delta[name]
+						}
+						return e.full[name]
+					})
+					if err != nil {
+						return err
+					}
+					deltas = append(deltas, v)
+				}
+			}
+		}
+
+		next := make(map[string]*relation)
+		for _, name := range stratum {
+			next[name] = newRelation(e.full[name].arity)
+		}
+		if err := e.evalVariants(initial, next); err != nil {
+			return err
+		}
+
+		for {
+			changed := false
+			for _, name := range stratum {
+				for _, t := range next[name].tuples {
+					e.full[name].add(t)
+				}
+				changed = changed || 0 < len(next[name].tuples)
+				e.delta[name], next[name] = next[name], newRelation(e.full[name].arity)
+			}
+			if !changed {
+				break
+			}
+			if err := e.evalVariants(deltas, next); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package query_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/leidegre/datoms/internal/query"
+	"github.com/leidegre/datoms/testutil"
+)
+
+// collect takes at most n tuples from a query
+func collect(t *testing.T, seq func(yield func([]any, error) bool), n int) [][]any {
+	var result [][]any
+	seq(func(tuple []any, err error) bool {
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, tuple)
+		return len(result) < n
+	})
+	return result
+}
+
+func TestSeq(t *testing.T) {
+	db := newDatabase(t)
+
+	qry := query.Query{
+		Find: []query.FindElem{query.Var("?name")},
+		Where: []query.Clause{
+			query.Pattern{query.Var("?e"), personName, query.Var("?name")},
+		},
+	}
+
+	names := strings(collect(t, query.Seq(qry, db), len(people)+1))
+	slices.Sort(names)
+
+	testutil.AreEqualSlice(t, strings(q(t, qry, db)), names)
+}
+
+func TestSeqStop(t *testing.T) {
+	db := newTreeDatabase(t, 2000)
+
+	qry := query.Query{
+		Find: []query.FindElem{query.Var("?name"), query.Var("?manager")},
+		Where: []query.Clause{
+			query.Pattern{query.Var("?e"), personManager, query.Var("?m")},
+			query.Pattern{query.Var("?e"), personName, query.Var("?name")},
+			query.Pattern{query.Var("?m"), personName, query.Var("?manager")},
+		},
+	}
+
+	for _, pool := range []*query.Pool{query.NewPool(1), query.NewPool(4)} {
+		testutil.AreEqual(t, 3, len(collect(t, pool.Seq(qry, db), 3)))
+	}
+}
+
+func TestSeqAggregate(t *testing.T) {
+	db := newDatabase(t)
+
+	result := collect(t, query.Seq(query.Query{
+		Find: []query.FindElem{query.Aggregate{Fn: "count", Args: []any{query.Var("?e")}}},
+		Where: []query.Clause{
+			query.Pattern{query.Var("?e"), personName},
+		},
+	}, db), 2)
+
+	testutil.AreEqual(t, 1, len(result))
+	testutil.AreEqual(t, int64(len(people)), result[0][0].(int64))
+}
+
+func TestSeqError(t *testing.T) {
+	db := newDatabase(t)
+
+	var errs []error
+	query.Seq(query.Query{
+		Find: []query.FindElem{query.Var("?x")},
+		Where: []query.Clause{
+			query.Pattern{query.Var("?e"), personName},
+		},
+	}, db)(func(_ []any, err error) bool {
+		errs = append(errs, err)
+		return true
+	})
+
+	testutil.AreEqual(t, 1, len(errs))
+	testutil.AreEqual(t, true, errs[0] != nil)
+}
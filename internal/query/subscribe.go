@@ -0,0 +1,376 @@
+package query
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/symbol"
+)
+
+// Change is the difference that a transaction made to the result of a subscribed query
+type Change struct {
+	DbAfter database.Interface
+	Added   [][]any
+	Removed [][]any
+	Err     error // the query failed and the subscription is closed
+}
+
+// Subscription keeps the result of a query up to date as transactions are
+// applied through a connection.
+//
+// Queries of data patterns, predicates and functions are maintained
+// incrementally. The transaction data is joined with the rest of the query
+// one pattern at a time, the patterns before it see the database after the
+// transaction and the patterns after it see the database before. Every
+// result tuple counts the ways that it's derived, it's added when the count
+// goes up from 0 and removed when it goes down to 0. Any other query is run
+// again after every transaction and the results are compared.
+type Subscription struct {
+	pool   *Pool
+	q      Query
+	inputs []any
+	src    int // the input of $
+	fn     func(Change)
+	cancel func()
+
+	mu     sync.Mutex
+	counts map[string]*derived
+	closed bool
+}
+
+type derived struct {
+	tuple []any
+	n     int
+}
+
+// Subscribe runs a query against the latest value of the database of conn
+// and calls fn with the changes to the result after every transaction. The
+// database is the input $, inputs are the other inputs of :in in order.
+func Subscribe(conn *database.Connection, q Query, fn func(Change), inputs ...any) (*Subscription, error) {
+	return DefaultPool.Subscribe(conn, q, fn, inputs...)
+}
+
+// Subscribe is like Subscribe but queries run using the workers of the pool
+func (p *Pool) Subscribe(conn *database.Connection, q Query, fn func(Change), inputs ...any) (*Subscription, error) {
+	in := q.In
+	if in == nil {
+		in = []Binding{DefaultSrc}
+	}
+	src := slices.Index(in, Binding(DefaultSrc))
+	if src == -1 {
+		return nil, fmt.Errorf("datoms: subscribed query has no %v input", DefaultSrc)
+	}
+	q.In = in
+
+	s := &Subscription{
+		pool:   p,
+		q:      q,
+		inputs: slices.Insert(slices.Clone(inputs), src, nil),
+		src:    src,
+		fn:     fn,
+	}
+
+	// transactions wait for the initial result
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	db, cancel := conn.Watch(func(tx database.Transaction) {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		change, ok := s.update(tx)
+		s.mu.Unlock()
+
+		// fn can use the subscription
+		if ok {
+			s.fn(change)
+		}
+	})
+	s.cancel = cancel
+
+	var err error
+	if s.counts, err = s.count(db); err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Result is the latest result of the query
+func (s *Subscription) Result() [][]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([][]any, 0, len(s.counts))
+	for _, d := range s.counts {
+		result = append(result, d.tuple)
+	}
+	return result
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.close()
+}
+
+func (s *Subscription) close() {
+	if !s.closed {
+		s.closed = true
+		s.cancel()
+	}
+}
+
+// update applies a transaction to the result and returns the change that fn
+// is called with, if any. s.mu must be locked.
+func (s *Subscription) update(tx database.Transaction) (change Change, ok bool) {
+	var (
+		counts = s.counts
+		err    error
+	)
+	if s.incremental() && !schemaChange(tx) {
+		counts, err = s.delta(tx)
+	} else {
+		counts, err = s.count(tx.DbAfter)
+	}
+	if err != nil {
+		s.close()
+		return Change{DbAfter: tx.DbAfter, Err: err}, true
+	}
+
+	for k, d := range s.counts {
+		if counts[k] == nil {
+			change.Removed = append(change.Removed, d.tuple)
+		}
+	}
+	for k, d := range counts {
+		if s.counts[k] == nil {
+			change.Added = append(change.Added, d.tuple)
+		}
+	}
+	s.counts = counts
+
+	if len(change.Added) == 0 && len(change.Removed) == 0 {
+		return Change{}, false
+	}
+	change.DbAfter = tx.DbAfter
+	return change, true
+}
+
+// incremental reports whether the query can be maintained incrementally
+func (s *Subscription) incremental() bool {
+	for _, elem := range s.q.Find {
+		if _, ok := elem.(Var); !ok {
+			return false
+		}
+	}
+	for _, clause := range s.q.Where {
+		switch clause := clause.(type) {
+		case Pattern:
+		case Predicate:
+			if slices.Contains(clause.Args, any(DefaultSrc)) {
+				return false
+			}
+		case Function:
+			if slices.Contains(clause.Args, any(DefaultSrc)) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// schemaChange reports whether the transaction changes what idents resolve
+// to or excises datoms, neither shows up in the transaction data as changes
+// to the datoms that queries match
+func schemaChange(tx database.Transaction) bool {
+	s := tx.DbAfter.Schema()
+	for _, d := range tx.TxData {
+		ident, _ := s.Ident(d.A)
+		switch ident {
+		case schema.DbIdent, schema.DbInstallAttribute, schema.DbExcise:
+			return true
+		}
+	}
+	return false
+}
+
+// count runs the query against db and counts the rows of every result tuple
+func (s *Subscription) count(db database.Interface) (map[string]*derived, error) {
+	inputs := slices.Clone(s.inputs)
+	inputs[s.src] = db
+
+	counts := make(map[string]*derived)
+
+	if !s.incremental() {
+		result, err := s.pool.Q(s.q, inputs...)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range result {
+			counts[tupleKey(t)] = &derived{t, 1}
+		}
+		return counts, nil
+	}
+
+	return counts, s.eval(s.q, inputs, counts, 1)
+}
+
+// eval adds sign to the count of every result tuple for every row of the query
+func (s *Subscription) eval(q Query, inputs []any, counts map[string]*derived, sign int) error {
+	e, err := newEngine(s.pool, q.In, inputs)
+	if err != nil {
+		return err
+	}
+	find, rows, err := e.prepare(q)
+	if err != nil {
+		return err
+	}
+	rows(func(r row) bool {
+		t := find.tuple(r)
+		k := tupleKey(t)
+		d := counts[k]
+		if d == nil {
+			d = &derived{tuple: t}
+			counts[k] = d
+		}
+		if d.n += sign; d.n == 0 {
+			delete(counts, k)
+		}
+		return true
+	})
+	return e.err
+}
+
+// sources that delta queries use in place of $
+const (
+	srcBefore Src = "$before"
+	srcAfter  Src = "$after"
+	srcDelta  Src = "$delta"
+)
+
+// delta updates the counts with the difference that the transaction made
+func (s *Subscription) delta(tx database.Transaction) (map[string]*derived, error) {
+	counts := make(map[string]*derived, len(s.counts))
+	for k, d := range s.counts {
+		counts[k] = &derived{d.tuple, d.n}
+	}
+
+	var added, retracted [][]any
+	for _, d := range tx.TxData {
+		before, wasCurrent := current(tx.DbBefore, d)
+		after, isCurrent := current(tx.DbAfter, d)
+		switch {
+		case d.Assertion() && isCurrent && !wasCurrent:
+			added = append(added, []any{after.E, after.A, after.V, after.Tx()})
+		case d.Retraction() && wasCurrent && !isCurrent:
+			retracted = append(retracted, []any{before.E, before.A, before.V, before.Tx()})
+		}
+	}
+
+	var (
+		in     = append(slices.Clone(s.q.In), srcBefore, srcAfter, srcDelta)
+		inputs = append(slices.Clone(s.inputs), tx.DbBefore, tx.DbAfter, nil)
+	)
+	inputs[s.src] = tx.DbAfter
+
+	for i, clause := range s.q.Where {
+		p, ok := clause.(Pattern)
+		if !ok || !isDefaultSrc(p) {
+			continue
+		}
+
+		dp, ok := deltaPattern(tx.DbAfter.Schema(), p)
+		if !ok {
+			continue // can't match anything
+		}
+
+		where := make([]Clause, len(s.q.Where))
+		for j, clause := range s.q.Where {
+			p, ok := clause.(Pattern)
+			switch {
+			case j == i:
+				where[j] = dp
+			case ok && isDefaultSrc(p) && j < i:
+				where[j] = withSrc(srcAfter, p)
+			case ok && isDefaultSrc(p):
+				where[j] = withSrc(srcBefore, p)
+			default:
+				where[j] = clause
+			}
+		}
+		q := Query{Find: s.q.Find, With: s.q.With, In: in, Where: where}
+
+		for _, delta := range []struct {
+			tuples [][]any
+			sign   int
+		}{{added, 1}, {retracted, -1}} {
+			if len(delta.tuples) == 0 {
+				continue
+			}
+			inputs[len(inputs)-1] = delta.tuples
+			if err := s.eval(q, inputs, counts, delta.sign); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return counts, nil
+}
+
+// current finds the assertion of the value of d that is current in db
+func current(db database.Interface, d base.Datom) (curr base.Datom, found bool) {
+	db.Datoms(base.EAVT, d.E, d.A, d.V)(func(d base.Datom) bool {
+		curr, found = d, true
+		return false
+	})
+	return
+}
+
+func isDefaultSrc(p Pattern) bool {
+	if 0 < len(p) {
+		if src, ok := p[0].(Src); ok {
+			return src == DefaultSrc
+		}
+	}
+	return true
+}
+
+func withSrc(src Src, p Pattern) Pattern {
+	if 0 < len(p) {
+		if _, ok := p[0].(Src); ok {
+			p = p[1:]
+		}
+	}
+	return append(Pattern{src}, p...)
+}
+
+// deltaPattern matches the pattern against the transaction data, the delta
+// source has entity IDs where the pattern can have idents
+func deltaPattern(s schema.Interface, p Pattern) (Pattern, bool) {
+	p = slices.Clone(withSrc(srcDelta, p))
+	for i := 1; i < len(p) && i <= 3; i++ {
+		if _, ok := p[i].(symbol.Keyword); !ok {
+			continue
+		}
+		if i == 3 {
+			if _, ref := refAttr(s, p[2]); !ref {
+				continue // a keyword value
+			}
+		}
+		id, err := entid(s, p[i])
+		if err != nil {
+			return nil, false
+		}
+		p[i] = id
+	}
+	return p, true
+}
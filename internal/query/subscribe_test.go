@@ -0,0 +1,188 @@
+package query_test
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/internal/query"
+	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/testutil"
+)
+
+func TestSubscribe(t *testing.T) {
+	queries := []struct {
+		q      query.Query
+		inputs []any
+	}{
+		{
+			// reports with their age
+			query.Query{
+				Find: []query.FindElem{query.Var("?name"), query.Var("?age")},
+				Where: []query.Clause{
+					query.Pattern{query.Var("?e"), personManager, query.Var("?m")},
+					query.Pattern{query.Var("?m"), personName, query.Var("?manager")},
+					query.Pattern{query.Var("?e"), personName, query.Var("?name")},
+					query.Pattern{query.Var("?e"), personAge, query.Var("?age")},
+					query.Predicate{Fn: "<", Args: []any{query.Var("?age"), 55}},
+				},
+			},
+			nil,
+		},
+		{
+			// managers, a manager with several reports is derived several times
+			query.Query{
+				Find: []query.FindElem{query.Var("?manager")},
+				In:   []query.Binding{query.Var("?min"), query.DefaultSrc},
+				Where: []query.Clause{
+					query.Pattern{query.Blank, personManager, query.Var("?m")},
+					query.Pattern{query.Var("?m"), personName, query.Var("?manager")},
+					query.Pattern{query.Var("?m"), personAge, query.Var("?age")},
+					query.Predicate{Fn: ">=", Args: []any{query.Var("?age"), query.Var("?min")}},
+				},
+			},
+			[]any{40},
+		},
+		{
+			// not is evaluated again after every transaction
+			query.Query{
+				Find: []query.FindElem{query.Var("?name")},
+				Where: []query.Clause{
+					query.Pattern{query.Var("?e"), personName, query.Var("?name")},
+					query.Not{Clauses: []query.Clause{
+						query.Pattern{query.Blank, personManager, query.Var("?e")},
+					}},
+				},
+			},
+			nil,
+		},
+	}
+
+	for i, c := range queries {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			conn := database.Connect(newDatabase(t))
+
+			id := func(name string) int64 {
+				result, err := query.Q(query.Query{
+					Find:  []query.FindElem{query.Var("?e")},
+					Where: []query.Clause{query.Pattern{query.Var("?e"), personName, name}},
+				}, conn.Db())
+				if err != nil || len(result) != 1 {
+					t.Fatalf("no %v", name)
+				}
+				return result[0][0].(int64)
+			}
+
+			var changes []query.Change
+			sub, err := query.Subscribe(conn, c.q, func(change query.Change) {
+				changes = append(changes, change)
+			}, c.inputs...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sub.Close()
+
+			// the result kept up to date with the changes
+			result := make(map[string][]any)
+			for _, t := range sub.Result() {
+				result[fmt.Sprint(t)] = t
+			}
+
+			eng3 := base.NewTempId(schema.DbPartUser)
+			txs := [][]base.TxData{
+				{
+					database.Add(eng3, personName, "eng3"),
+					database.Add(eng3, personAge, int64(22)),
+					database.Add(eng3, personManager, base.Entity{Id: id("eng1")}),
+				},
+				{database.Add(base.Entity{Id: id("vp2")}, personAge, int64(56))},
+				{database.Add(base.Entity{Id: id("eng2")}, personManager, base.Entity{Id: id("vp2")})},
+				{database.Retract(base.Entity{Id: id("eng1")}, personName, "eng1")},
+				{database.Retract(base.Entity{Id: id("vp1")}, personManager, id("ceo"))},
+				{database.Retract(base.Entity{Id: id("vp2")}, personAge, int64(0))}, // not the current value
+			}
+
+			for _, txData := range txs {
+				if _, err := conn.Transact(txData); err != nil {
+					t.Fatal(err)
+				}
+
+				for _, change := range changes {
+					if change.Err != nil {
+						t.Fatal(change.Err)
+					}
+					for _, t := range change.Removed {
+						delete(result, fmt.Sprint(t))
+					}
+					for _, t := range change.Added {
+						result[fmt.Sprint(t)] = t
+					}
+				}
+				changes = nil
+
+				inputs := []any{conn.Db()}
+				if c.q.In != nil {
+					inputs = slices.Insert(slices.Clone(c.inputs), slices.Index(c.q.In, query.Binding(query.DefaultSrc)), any(conn.Db()))
+				}
+				expected, err := query.Q(c.q, inputs...)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var actual [][]any
+				for _, t := range result {
+					actual = append(actual, t)
+				}
+				testutil.AreEqualSlice(t, sorted(expected), sorted(actual))
+				testutil.AreEqualSlice(t, sorted(expected), sorted(sub.Result()))
+			}
+		})
+	}
+}
+
+func TestSubscribeClose(t *testing.T) {
+	conn := database.Connect(newDatabase(t))
+
+	n := 0
+	sub, err := query.Subscribe(conn, namesQuery, func(query.Change) { n++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conn.Transact([]base.TxData{database.Add(base.NewTempId(schema.DbPartUser), personName, "eng3")}); err != nil {
+		t.Fatal(err)
+	}
+	sub.Close()
+	if _, err := conn.Transact([]base.TxData{database.Add(base.NewTempId(schema.DbPartUser), personName, "eng4")}); err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.AreEqual(t, 1, n)
+	testutil.AreEqual(t, len(people)+1, len(sub.Result()))
+}
+
+func TestSubscribeCallback(t *testing.T) {
+	conn := database.Connect(newDatabase(t))
+
+	var (
+		sub    *query.Subscription
+		result [][]any
+	)
+	sub, err := query.Subscribe(conn, namesQuery, func(query.Change) {
+		result = sub.Result()
+		sub.Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"eng3", "eng4"} {
+		if _, err := conn.Transact([]base.TxData{database.Add(base.NewTempId(schema.DbPartUser), personName, name)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testutil.AreEqual(t, len(people)+1, len(result))
+}
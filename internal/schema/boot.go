@@ -43,6 +43,14 @@ const (
 	dbTxInstant
 
 	dbDoc
+
+	dbNoHistory
+
+	dbExcise
+	dbExciseAttrs
+	dbExciseBeforeT
+
+	dbIndex
 )
 
 var (
@@ -81,6 +89,14 @@ var (
 	DbTxInstant = symbol.For(":db/txInstant")
 
 	DbDoc = symbol.For(":db/doc")
+
+	DbNoHistory = symbol.For(":db/noHistory")
+
+	DbIndex = symbol.For(":db/index") // recorded only, AVET has every datom
+
+	DbExcise        = symbol.For(":db/excise")         // ref to entity or attribute to excise
+	DbExciseAttrs   = symbol.For(":db.excise/attrs")   // limits entity excision to these attributes
+	DbExciseBeforeT = symbol.For(":db.excise/beforeT") // limits excision to datoms before this T
 )
 
 type bootstrappingPart struct {
@@ -146,6 +162,11 @@ func BootstrappingPart(baseT int64) []base.Datom {
 	part.defineAttribute(dbIsComponent, DbIsComponent, dbTypeBool, dbCardinalityOne)
 	part.defineAttribute(dbDoc, DbDoc, dbTypeString, dbCardinalityOne)
 	part.defineAttribute(dbTxInstant, DbTxInstant, dbTypeTime, dbCardinalityOne)
+	part.defineAttribute(dbNoHistory, DbNoHistory, dbTypeBool, dbCardinalityOne)
+	part.defineAttribute(dbExcise, DbExcise, dbTypeRef, dbCardinalityOne)
+	part.defineAttribute(dbExciseAttrs, DbExciseAttrs, dbTypeRef, dbCardinalityMany)
+	part.defineAttribute(dbExciseBeforeT, DbExciseBeforeT, dbTypeInt64, dbCardinalityOne)
+	part.defineAttribute(dbIndex, DbIndex, dbTypeBool, dbCardinalityOne)
 
 	part.defineEntity(dbCardinalityOne, DbCardinalityOne)
 	part.defineEntity(dbCardinalityMany, DbCardinalityMany)
@@ -19,24 +19,79 @@ type Attr struct {
 	Unique      int64          `ident:":db/unique"`
 	IsComponent bool           `ident:":db/isComponent"`
 	Doc         string         `ident:":db/doc"`
+	NoHistory   bool           `ident:":db/noHistory"`
+	Index       bool           `ident:":db/index"`
 }
 
 type Interface interface {
 	Id(ident symbol.Keyword) (id int64, ok bool)
+	Ident(id int64) (ident symbol.Keyword, ok bool)
+	IdentHistory(id int64) []symbol.Keyword
 	Attr(attrId int64) (attr Attr, ok bool)
 	AttrKeyword(attrIdent symbol.Keyword) (attr Attr, ok bool)
+	Partition(partIdent symbol.Keyword) (partId int64, ok bool)
+
+	Attrs() []Attr
+	Partitions() []base.Entity
+	ValueTypes() []base.Entity
+	Idents() []base.Entity
+}
+
+// identNames is the ident history of an entity. An entity that has been renamed
+// keeps its old idents as aliases until another entity claims them.
+type identNames struct {
+	ident   symbol.Keyword   // current ident, zero if retracted or claimed
+	history []symbol.Keyword // oldest first
+}
+
+func (n *identNames) with(ident symbol.Keyword) *identNames {
+	history := n.without(ident).history
+	return &identNames{ident: ident, history: append(history, ident)}
+}
+
+func (n *identNames) without(ident symbol.Keyword) *identNames {
+	var tmp identNames
+	if n.ident != ident {
+		tmp.ident = n.ident
+	}
+	for _, alias := range n.history {
+		if alias != ident {
+			tmp.history = append(tmp.history, alias)
+		}
+	}
+	return &tmp
 }
 
 type Schema struct {
-	idents hamt.Persistent[symbol.Keyword, int64]
-	attrs  hamt.Persistent[int64, Attr]
+	idents    hamt.Persistent[symbol.Keyword, int64] // includes aliases
+	names     hamt.Persistent[int64, *identNames]
+	attrs     hamt.Persistent[int64, Attr]
+	installed hamt.Persistent[int64, bootId] // partitions and value types
 }
 
+// Id resolves an ident, or an alias of a renamed ident, to an entity ID
 func (s *Schema) Id(ident symbol.Keyword) (id int64, ok bool) {
 	id, ok = s.idents.Get(ident, ident.Hash())
 	return
 }
 
+// Ident looks up the current ident of an entity
+func (s *Schema) Ident(id int64) (ident symbol.Keyword, ok bool) {
+	if n, found := s.names.Get(id, hash.Uint64(uint64(id))); found {
+		ident, ok = n.ident, n.ident != symbol.Keyword{}
+	}
+	return
+}
+
+// IdentHistory lists every ident that has resolved to the entity and still
+// does, oldest first. The current ident, if any, is the last one.
+func (s *Schema) IdentHistory(id int64) []symbol.Keyword {
+	if n, ok := s.names.Get(id, hash.Uint64(uint64(id))); ok {
+		return slices.Clone(n.history)
+	}
+	return nil
+}
+
 // Attr looks up attribute by entid
 func (s *Schema) Attr(attrId int64) (attr Attr, ok bool) {
 	attr, ok = s.attrs.Get(attrId, hash.Uint64(uint64(attrId)))
@@ -52,6 +107,67 @@ func (s *Schema) AttrKeyword(attrIdent symbol.Keyword) (attr Attr, ok bool) {
 	return
 }
 
+// Partition looks up an installed partition by ident
+func (s *Schema) Partition(partIdent symbol.Keyword) (partId int64, ok bool) {
+	if partId, ok = s.Id(partIdent); ok {
+		var kind bootId
+		kind, ok = s.installed.Get(partId, hash.Uint64(uint64(partId)))
+		ok = ok && kind == dbInstallPartition
+	}
+	return
+}
+
+// Attrs lists all installed attributes ordered by entid
+func (s *Schema) Attrs() []Attr {
+	var attrs []Attr
+	s.attrs.All()(func(_ int64, attr Attr) bool {
+		attrs = append(attrs, attr)
+		return true
+	})
+	slices.SortFunc(attrs, func(x, y Attr) int { return sort.CompareOrdered(x.Id, y.Id) })
+	return attrs
+}
+
+// Partitions lists all installed partitions ordered by entid
+func (s *Schema) Partitions() []base.Entity {
+	return s.installedEntities(dbInstallPartition)
+}
+
+// ValueTypes lists all installed value types ordered by entid
+func (s *Schema) ValueTypes() []base.Entity {
+	return s.installedEntities(dbInstallValueType)
+}
+
+func (s *Schema) installedEntities(kind bootId) []base.Entity {
+	var ents []base.Entity
+	s.installed.All()(func(id int64, v bootId) bool {
+		if v == kind {
+			ident, _ := s.Ident(id)
+			ents = append(ents, base.Entity{Id: id, Ident: ident})
+		}
+		return true
+	})
+	slices.SortFunc(ents, compareEntity)
+	return ents
+}
+
+// Idents lists the current ident of every entity that has one ordered by entid
+func (s *Schema) Idents() []base.Entity {
+	var ents []base.Entity
+	s.names.All()(func(id int64, n *identNames) bool {
+		if n.ident != (symbol.Keyword{}) {
+			ents = append(ents, base.Entity{Id: id, Ident: n.ident})
+		}
+		return true
+	})
+	slices.SortFunc(ents, compareEntity)
+	return ents
+}
+
+func compareEntity(x, y base.Entity) int {
+	return sort.CompareOrdered(x.Id, y.Id)
+}
+
 func (s *Schema) attrId(ident symbol.Keyword) uint32 {
 	if id, ok := s.Id(ident); ok {
 		return uint32(id)
@@ -65,10 +181,19 @@ func (s *Schema) With(data []base.Datom) *Schema {
 	slices.SortFunc(data, sort.CompareIndex(base.EAVT))
 
 	var (
-		idents = s.idents
-		attrs  = s.attrs
+		idents    = s.idents
+		names     = s.names
+		attrs     = s.attrs
+		installed = s.installed
 	)
 
+	entityNames := func(id int64) *identNames {
+		if n, ok := names.Get(id, hash.Uint64(uint64(id))); ok {
+			return n
+		}
+		return &identNames{}
+	}
+
 	type elem struct {
 		Id          int64
 		Ident       symbol.Keyword
@@ -76,6 +201,13 @@ func (s *Schema) With(data []base.Datom) *Schema {
 		Cardinality int64
 		Unique      int64
 		IsComponent bool
+		Doc         string
+		NoHistory   bool
+		Index       bool
+
+		hasDoc       bool
+		hasNoHistory bool
+		hasIndex     bool
 	}
 
 	var (
@@ -92,19 +224,31 @@ func (s *Schema) With(data []base.Datom) *Schema {
 			el = elem{Id: d.E}
 		}
 		// We have to use bootstrapping partition IDs statically to get going
-		switch bootId(d.A) {
-		case dbInstallAttribute:
+		switch a := bootId(d.A); a {
+		case dbInstallAttribute, dbInstallPartition, dbInstallValueType:
 			// E will be :datoms.part/db
 			// A will be :datoms.install/attribute
 			// V will be entid of attribute
 			if install == nil {
 				install = make(map[int64]bootId)
 			}
-			install[d.V.(int64)] = dbInstallAttribute
+			install[d.V.(int64)] = a
 		case dbIdent:
 			ident := d.V.(symbol.Keyword)
+			if d.Retraction() {
+				// A retracted ident is no longer the current ident of the
+				// entity but it keeps resolving until it is claimed
+				if n := entityNames(d.E); n.ident == ident {
+					names = names.Set(d.E, hash.Uint64(uint64(d.E)), &identNames{history: n.history})
+				}
+				continue
+			}
+			if prev, ok := idents.Get(ident, ident.Hash()); ok && prev != d.E {
+				names = names.Set(prev, hash.Uint64(uint64(prev)), entityNames(prev).without(ident))
+			}
 			el.Ident = ident
 			idents = idents.Set(ident, ident.Hash(), d.E)
+			names = names.Set(d.E, hash.Uint64(uint64(d.E)), entityNames(d.E).with(ident))
 		case dbValueType:
 			el.ValueType = d.V.(int64)
 		case dbCardinality:
@@ -113,35 +257,77 @@ func (s *Schema) With(data []base.Datom) *Schema {
 			el.Unique = d.V.(int64)
 		case dbIsComponent:
 			el.IsComponent = d.V.(bool)
+		case dbDoc:
+			// The retraction of the old doc can come before or after the
+			// assertion of the new one
+			if d.Assertion() {
+				el.Doc = d.V.(string)
+			} else if !el.hasDoc {
+				el.Doc = ""
+			}
+			el.hasDoc = true
+		case dbNoHistory:
+			el.NoHistory = d.V.(bool) && d.Assertion()
+			el.hasNoHistory = true
+		case dbIndex:
+			if d.Assertion() {
+				el.Index = d.V.(bool)
+			} else if !el.hasIndex {
+				el.Index = false
+			}
+			el.hasIndex = true
 		}
 	}
 	if el.Id != 0 {
 		els = append(els, el)
 	}
 
-	if install != nil {
-		for _, el := range els {
-			k, h := el.Id, hash.Uint64(uint64(el.Id))
-			if v, ok := install[k]; ok {
-				switch v {
-				case dbInstallAttribute:
-					var attr Attr
-					if attr, ok = s.attrs.Get(k, h); !ok {
-						attr.Id = k
-					}
-					attr.Ident = el.Ident
-					attr.ValueType = el.ValueType
-					attr.Cardinality = el.Cardinality
-					attr.Unique = el.Unique
-					attr.IsComponent = el.IsComponent
-					attrs = attrs.Set(k, h, attr)
+	for _, el := range els {
+		k, h := el.Id, hash.Uint64(uint64(el.Id))
+		if v, ok := install[k]; ok {
+			switch v {
+			case dbInstallAttribute:
+				var attr Attr
+				if attr, ok = s.attrs.Get(k, h); !ok {
+					attr.Id = k
 				}
+				attr.Ident = el.Ident
+				attr.ValueType = el.ValueType
+				attr.Cardinality = el.Cardinality
+				attr.Unique = el.Unique
+				attr.IsComponent = el.IsComponent
+				attr.Doc = el.Doc
+				attr.NoHistory = el.NoHistory
+				attr.Index = el.Index
+				attrs = attrs.Set(k, h, attr)
+			}
+		} else if attr, ok := s.attrs.Get(k, h); ok {
+			// renamed or documented attribute
+			if el.Ident != (symbol.Keyword{}) {
+				attr.Ident = el.Ident
 			}
+			if el.hasDoc {
+				attr.Doc = el.Doc
+			}
+			if el.hasNoHistory {
+				attr.NoHistory = el.NoHistory
+			}
+			if el.hasIndex {
+				attr.Index = el.Index
+			}
+			attrs = attrs.Set(k, h, attr)
+		}
+	}
+
+	for k, v := range install {
+		switch v {
+		case dbInstallPartition, dbInstallValueType:
+			installed = installed.Set(k, hash.Uint64(uint64(k)), v)
 		}
 	}
 
-	if s.idents != idents || s.attrs != attrs {
-		return &Schema{idents: idents, attrs: attrs}
+	if s.idents != idents || s.names != names || s.attrs != attrs || s.installed != installed {
+		return &Schema{idents: idents, names: names, attrs: attrs, installed: installed}
 	}
 
 	return s
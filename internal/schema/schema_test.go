@@ -3,7 +3,9 @@ package schema_test
 import (
 	"testing"
 
+	"github.com/leidegre/datoms/internal/base"
 	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/symbol"
 	"github.com/leidegre/datoms/testutil"
 )
 
@@ -61,3 +63,198 @@ func TestSchemaBootstrappingPart(t *testing.T) {
 		t.Fatal("cannot find attribute :db/doc")
 	}
 }
+
+func TestIdentHistory(t *testing.T) {
+	s := schema.New()
+
+	var (
+		ident, _ = s.Id(schema.DbIdent)
+		foo      = symbol.For(":test/foo")
+		bar      = symbol.For(":test/bar")
+		baz      = symbol.For(":test/baz")
+	)
+
+	const (
+		e1 int64 = 1001
+		e2 int64 = 1002
+	)
+
+	s = s.With([]base.Datom{base.NewDatom(e1, ident, foo, 1, 1)})
+
+	// rename :test/foo to :test/bar
+	s = s.With([]base.Datom{
+		base.NewDatom(e1, ident, foo, 2, 0),
+		base.NewDatom(e1, ident, bar, 2, 1),
+	})
+
+	if id, ok := s.Id(foo); !(ok && id == e1) {
+		t.Fatal("old ident should resolve to the renamed entity")
+	}
+
+	if id, ok := s.Id(bar); !(ok && id == e1) {
+		t.Fatal("new ident should resolve to the renamed entity")
+	}
+
+	if current, ok := s.Ident(e1); !(ok && current == bar) {
+		t.Fatalf("expected current ident %v actual %v", bar, current)
+	}
+
+	testutil.AreEqualSlice(t, []symbol.Keyword{foo, bar}, s.IdentHistory(e1))
+
+	// rename again and then let another entity claim :test/foo
+	s = s.With([]base.Datom{base.NewDatom(e1, ident, baz, 3, 1)})
+	s = s.With([]base.Datom{base.NewDatom(e2, ident, foo, 4, 1)})
+
+	if id, ok := s.Id(foo); !(ok && id == e2) {
+		t.Fatal("claimed ident should resolve to the new entity")
+	}
+
+	testutil.AreEqualSlice(t, []symbol.Keyword{bar, baz}, s.IdentHistory(e1))
+	testutil.AreEqualSlice(t, []symbol.Keyword{foo}, s.IdentHistory(e2))
+}
+
+func TestRenameAttribute(t *testing.T) {
+	s := schema.New()
+
+	var (
+		ident, _ = s.Id(schema.DbIdent)
+		doc, _   = s.Id(schema.DbDoc)
+		docs     = symbol.For(":db/docs")
+	)
+
+	s = s.With([]base.Datom{
+		base.NewDatom(doc, ident, schema.DbDoc, 1, 0),
+		base.NewDatom(doc, ident, docs, 1, 1),
+	})
+
+	if attr, ok := s.AttrKeyword(schema.DbDoc); !(ok && attr.Id == doc && attr.Ident == docs) {
+		t.Fatal("old ident should resolve to the renamed attribute")
+	}
+
+	if attr, ok := s.AttrKeyword(docs); !(ok && attr.Id == doc && attr.Ident == docs) {
+		t.Fatal("new ident should resolve to the renamed attribute")
+	}
+}
+
+func TestRetractDoc(t *testing.T) {
+	s := schema.New()
+
+	var (
+		ident, _ = s.Id(schema.DbIdent)
+		doc, _   = s.Id(schema.DbDoc)
+	)
+
+	s = s.With([]base.Datom{base.NewDatom(ident, doc, "foo", 1, 1)})
+
+	// the retraction of the old doc comes after the assertion of the new one
+	s = s.With([]base.Datom{
+		base.NewDatom(ident, doc, "bar", 2, 1),
+		base.NewDatom(ident, doc, "foo", 2, 0),
+	})
+
+	if attr, _ := s.Attr(ident); attr.Doc != "bar" {
+		t.Fatalf("expected :db/doc to be replaced, actual %q", attr.Doc)
+	}
+
+	s = s.With([]base.Datom{base.NewDatom(ident, doc, "bar", 3, 0)})
+
+	if attr, _ := s.Attr(ident); attr.Doc != "" {
+		t.Fatalf("expected :db/doc to be retracted, actual %q", attr.Doc)
+	}
+}
+
+func TestAlterIndex(t *testing.T) {
+	s := schema.New()
+
+	var (
+		ident, _ = s.Id(schema.DbIdent)
+		index, _ = s.Id(schema.DbIndex)
+	)
+
+	s = s.With([]base.Datom{base.NewDatom(ident, index, true, 1, 1)})
+
+	if attr, _ := s.Attr(ident); !attr.Index {
+		t.Fatal("expected :db/index to be altered")
+	}
+
+	s = s.With([]base.Datom{base.NewDatom(ident, index, true, 2, 0)})
+
+	if attr, _ := s.Attr(ident); attr.Index {
+		t.Fatal("expected :db/index to be retracted")
+	}
+}
+
+func TestSchemaIntrospection(t *testing.T) {
+	s := schema.New()
+
+	var (
+		ident, _ = s.Id(schema.DbIdent)
+		doc, _   = s.Id(schema.DbDoc)
+	)
+
+	s = s.With([]base.Datom{base.NewDatom(ident, doc, "Attribute used to uniquely name an entity.", 1, 1)})
+
+	if attr, ok := s.Attr(ident); !(ok && attr.Doc == "Attribute used to uniquely name an entity.") {
+		t.Fatalf("expected :db/doc to be loaded, actual %q", attr.Doc)
+	}
+
+	var partitions []symbol.Keyword
+	for _, part := range s.Partitions() {
+		partitions = append(partitions, part.Ident)
+	}
+	testutil.AreEqualSlice(t, []symbol.Keyword{schema.DbPartDb, schema.DbPartTx, schema.DbPartUser}, partitions)
+
+	var valueTypes []symbol.Keyword
+	for _, valueType := range s.ValueTypes() {
+		valueTypes = append(valueTypes, valueType.Ident)
+	}
+	testutil.AreEqualSlice(t, []symbol.Keyword{
+		schema.DbTypeBoolean,
+		schema.DbTypeDouble,
+		schema.DbTypeString,
+		schema.DbTypeLong,
+		schema.DbTypeRef,
+		schema.DbTypeKeyword,
+		schema.DbTypeInstant,
+	}, valueTypes)
+
+	attrs := s.Attrs()
+	testutil.AreEqual(t, 15, len(attrs))
+	for i, attr := range attrs {
+		if 0 < i && !(attrs[i-1].Id < attr.Id) {
+			t.Fatal("attributes should be ordered by entid")
+		}
+		if id, ok := s.Id(attr.Ident); !(ok && id == attr.Id) {
+			t.Fatalf("attribute %v does not resolve", attr.Ident)
+		}
+	}
+
+	idents := s.Idents()
+	testutil.AreEqual(t, 3+7+15+4, len(idents))
+}
+
+func TestPartition(t *testing.T) {
+	s := schema.New()
+
+	var (
+		ident, _       = s.Id(schema.DbIdent)
+		partDb, _      = s.Id(schema.DbPartDb)
+		installPart, _ = s.Id(schema.DbInstallPartition)
+		foo            = symbol.For(":test.part/foo")
+	)
+
+	if _, ok := s.Partition(schema.DbDoc); ok {
+		t.Fatal(":db/doc is not a partition")
+	}
+
+	s = s.With([]base.Datom{
+		base.NewDatom(1000, ident, foo, 1, 1),
+		base.NewDatom(partDb, installPart, int64(1000), 1, 1),
+	})
+
+	if id, ok := s.Partition(foo); !(ok && id == 1000) {
+		t.Fatal("expected partition to be installed")
+	}
+
+	testutil.AreEqual(t, 4, len(s.Partitions()))
+}
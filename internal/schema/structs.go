@@ -0,0 +1,241 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/symbol"
+)
+
+var (
+	entityLikeType = reflect.TypeOf((*base.EntityLike)(nil)).Elem()
+	keywordType    = reflect.TypeOf(symbol.Keyword{})
+	timeType       = reflect.TypeOf(time.Time{})
+)
+
+// structAttr is an attribute as declared by a struct field
+type structAttr struct {
+	Attr
+	valueType   symbol.Keyword
+	cardinality symbol.Keyword
+	unique      symbol.Keyword
+	field       string // where it is declared
+}
+
+// FromStructs makes the transaction that installs the attributes that the
+// fields of struct types are tagged with, like
+//
+//	type Person struct {
+//		base.Entity
+//		Email   string    `ident:":person/email,unique=identity" doc:"The email address"`
+//		Friends []*Person `ident:":person/friend,index"`
+//	}
+//
+// The value type follows from the Go type, structs that embed base.Entity
+// are refs and slices are cardinality many. The tag options are
+// unique=value, unique=identity, index, component, noHistory and ref, ref
+// makes an int64 field a ref. Nested entity types are included. Types are
+// struct values, pointers to structs or reflect.Type.
+func FromStructs(types ...any) ([]base.TxData, error) {
+	var (
+		attrs = make(map[symbol.Keyword]*structAttr)
+		seen  = make(map[reflect.Type]bool)
+		errs  []error
+	)
+
+	var visit func(t reflect.Type, prefix string)
+	visit = func(t reflect.Type, prefix string) {
+		for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct || seen[t] {
+			return
+		}
+		seen[t] = true
+		if prefix == "" {
+			prefix = t.String()
+		}
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if !sf.IsExported() {
+				continue
+			}
+			tag := sf.Tag.Get("ident")
+			if tag == "" {
+				if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+					visit(sf.Type, prefix) // embedded fields belong to the outer struct
+					seen[sf.Type] = false
+				}
+				continue
+			}
+			attr, err := structField(sf, tag)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%v.%v: %w", prefix, sf.Name, err))
+				continue
+			}
+			if attr == nil {
+				continue // :db/id and :db/ident
+			}
+			attr.field = prefix + "." + sf.Name
+			if prev, ok := attrs[attr.Ident]; ok {
+				if err := conflict(prev, attr); err != nil {
+					errs = append(errs, err)
+				}
+				if prev.Doc == "" {
+					prev.Doc = attr.Doc
+				}
+			} else {
+				attrs[attr.Ident] = attr
+			}
+			visit(sf.Type, "")
+		}
+	}
+
+	for _, v := range types {
+		t, ok := v.(reflect.Type)
+		if !ok {
+			t = reflect.TypeOf(v)
+		}
+		if t == nil || !(t.Kind() == reflect.Struct || t.Kind() == reflect.Pointer && t.Elem().Kind() == reflect.Struct) {
+			errs = append(errs, fmt.Errorf("datoms: %T is not a struct type", v))
+			continue
+		}
+		visit(t, "")
+	}
+
+	if errs != nil {
+		return nil, errors.Join(errs...)
+	}
+
+	idents := make([]symbol.Keyword, 0, len(attrs))
+	for ident := range attrs {
+		idents = append(idents, ident)
+	}
+	slices.SortFunc(idents, func(x, y symbol.Keyword) int { return strings.Compare(x.String(), y.String()) })
+
+	var txData []base.TxData
+	for _, ident := range idents {
+		attr := attrs[ident]
+		id := base.NewTempId(DbPartDb)
+		txData = append(txData,
+			base.TxAdd{E: id, A: DbIdent, V: attr.Ident},
+			base.TxAdd{E: id, A: DbValueType, V: attr.valueType},
+			base.TxAdd{E: id, A: DbCardinality, V: attr.cardinality},
+		)
+		if attr.unique != (symbol.Keyword{}) {
+			txData = append(txData, base.TxAdd{E: id, A: DbUnique, V: attr.unique})
+		}
+		if attr.IsComponent {
+			txData = append(txData, base.TxAdd{E: id, A: DbIsComponent, V: true})
+		}
+		if attr.NoHistory {
+			txData = append(txData, base.TxAdd{E: id, A: DbNoHistory, V: true})
+		}
+		if attr.Index {
+			txData = append(txData, base.TxAdd{E: id, A: DbIndex, V: true})
+		}
+		if attr.Doc != "" {
+			txData = append(txData, base.TxAdd{E: id, A: DbDoc, V: attr.Doc})
+		}
+		txData = append(txData, base.TxAdd{E: base.Entity{Ident: DbPartDb}, A: DbInstallAttribute, V: id})
+	}
+	return txData, nil
+}
+
+// structField is nil for :db/id and :db/ident
+func structField(sf reflect.StructField, tag string) (*structAttr, error) {
+	ident, opts, _ := strings.Cut(tag, ",")
+	attr := &structAttr{Attr: Attr{Ident: symbol.For(ident), Doc: sf.Tag.Get("doc")}}
+	if attr.Ident == DbId || attr.Ident == DbIdent {
+		return nil, nil
+	}
+
+	ref := false
+	for _, opt := range strings.Split(opts, ",") {
+		switch opt {
+		case "":
+		case "unique=value":
+			attr.unique = DbUniqueValue
+		case "unique=identity":
+			attr.unique = DbUniqueIdentity
+		case "index":
+			attr.Index = true
+		case "component":
+			attr.IsComponent = true
+		case "noHistory":
+			attr.NoHistory = true
+		case "ref":
+			ref = true
+		default:
+			return nil, fmt.Errorf("datoms: unknown option %q of %v", opt, ident)
+		}
+	}
+
+	t := sf.Type
+	attr.cardinality = DbCardinalityOne
+	if t.Kind() == reflect.Slice {
+		attr.cardinality = DbCardinalityMany
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == keywordType:
+		attr.valueType = DbTypeKeyword
+	case t == timeType:
+		attr.valueType = DbTypeInstant
+	case t.Kind() == reflect.Struct && t.Implements(entityLikeType):
+		attr.valueType = DbTypeRef
+	default:
+		switch t.Kind() {
+		case reflect.Bool:
+			attr.valueType = DbTypeBoolean
+		case reflect.String:
+			attr.valueType = DbTypeString
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint8, reflect.Uint16, reflect.Uint32:
+			attr.valueType = DbTypeLong
+			if ref {
+				attr.valueType = DbTypeRef
+			}
+		case reflect.Float32, reflect.Float64:
+			attr.valueType = DbTypeDouble
+		default:
+			return nil, fmt.Errorf("datoms: no value type for %v of %v", sf.Type, ident)
+		}
+	}
+	if ref && attr.valueType != DbTypeRef {
+		return nil, fmt.Errorf("datoms: %v of %v cannot be a ref", sf.Type, ident)
+	}
+	if attr.IsComponent && attr.valueType != DbTypeRef {
+		return nil, fmt.Errorf("datoms: %v is a component but not a ref", ident)
+	}
+	return attr, nil
+}
+
+func conflict(prev, attr *structAttr) error {
+	var diffs []string
+	if prev.valueType != attr.valueType {
+		diffs = append(diffs, fmt.Sprintf("%v and %v", prev.valueType, attr.valueType))
+	}
+	if prev.cardinality != attr.cardinality {
+		diffs = append(diffs, fmt.Sprintf("%v and %v", prev.cardinality, attr.cardinality))
+	}
+	if prev.unique != attr.unique || prev.IsComponent != attr.IsComponent || prev.NoHistory != attr.NoHistory || prev.Index != attr.Index {
+		diffs = append(diffs, "different options")
+	}
+	if prev.Doc != "" && attr.Doc != "" && prev.Doc != attr.Doc {
+		diffs = append(diffs, "different docs")
+	}
+	if diffs == nil {
+		return nil
+	}
+	return fmt.Errorf("datoms: %v is declared by %v and %v with %v", attr.Ident, prev.field, attr.field, strings.Join(diffs, ", "))
+}
@@ -0,0 +1,112 @@
+package schema_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/storage/mem"
+	"github.com/leidegre/datoms/symbol"
+	"github.com/leidegre/datoms/testutil"
+)
+
+type address struct {
+	base.Entity
+	City string `ident:":address/city"`
+}
+
+type person struct {
+	base.Entity
+	Email    string         `ident:":person/email,unique=identity" doc:"The email address"`
+	Name     string         `ident:":person/name,index"`
+	Age      *int           `ident:":person/age,noHistory"`
+	Born     time.Time      `ident:":person/born"`
+	Status   symbol.Keyword `ident:":person/status"`
+	Tags     []string       `ident:":person/tags"`
+	Address  *address       `ident:":person/address,component"`
+	Friends  []*person      `ident:":person/friends"`
+	Manager  int64          `ident:":person/manager,ref"`
+	internal string
+}
+
+func TestFromStructs(t *testing.T) {
+	txData, err := schema.FromStructs(person{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := mem.New().With(txData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := tx.DbAfter.Schema()
+
+	id := func(ident symbol.Keyword) int64 {
+		id, _ := s.Id(ident)
+		return id
+	}
+	attr := func(ident string) schema.Attr {
+		attr, ok := s.AttrKeyword(symbol.For(ident))
+		if !ok {
+			t.Fatalf("no attribute %v", ident)
+		}
+		return attr
+	}
+
+	for _, test := range []struct {
+		ident       string
+		valueType   symbol.Keyword
+		cardinality symbol.Keyword
+	}{
+		{":person/email", schema.DbTypeString, schema.DbCardinalityOne},
+		{":person/age", schema.DbTypeLong, schema.DbCardinalityOne},
+		{":person/born", schema.DbTypeInstant, schema.DbCardinalityOne},
+		{":person/status", schema.DbTypeKeyword, schema.DbCardinalityOne},
+		{":person/tags", schema.DbTypeString, schema.DbCardinalityMany},
+		{":person/address", schema.DbTypeRef, schema.DbCardinalityOne},
+		{":person/friends", schema.DbTypeRef, schema.DbCardinalityMany},
+		{":person/manager", schema.DbTypeRef, schema.DbCardinalityOne},
+		{":address/city", schema.DbTypeString, schema.DbCardinalityOne}, // nested
+	} {
+		attr := attr(test.ident)
+		testutil.AreEqual(t, id(test.valueType), attr.ValueType)
+		testutil.AreEqual(t, id(test.cardinality), attr.Cardinality)
+	}
+
+	testutil.AreEqual(t, id(schema.DbUniqueIdentity), attr(":person/email").Unique)
+	testutil.AreEqual(t, "The email address", attr(":person/email").Doc)
+	testutil.AreEqual(t, true, attr(":person/name").Index)
+	testutil.AreEqual(t, true, attr(":person/age").NoHistory)
+	testutil.AreEqual(t, true, attr(":person/address").IsComponent)
+}
+
+func TestFromStructsConflict(t *testing.T) {
+	type other struct {
+		base.Entity
+		Name []string `ident:":person/name"`
+		City int64    `ident:":address/city"`
+	}
+
+	_, err := schema.FromStructs(person{}, &other{})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, s := range []string{":person/name", ":address/city", "schema_test.other.Name", "schema_test.person.Name"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("expected %v in %v", s, err)
+		}
+	}
+
+	type invalid struct {
+		Name  string            `ident:":person/name,unique"`
+		Score float64           `ident:":person/score,ref"`
+		Data  map[string]string `ident:":person/data"`
+	}
+	_, err = schema.FromStructs(invalid{})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	testutil.AreEqual(t, 3, len(strings.Split(err.Error(), "\n")))
+}
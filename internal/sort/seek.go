@@ -16,45 +16,77 @@ func ResolveEntid(v any) int64 {
 	}
 }
 
+// Target makes a datom from the leading components of index. Components are
+// given in index order, e.g. E, A, V, T for EAVT and V, A, E, T for VAET.
 func Target(index base.Index, components []any) (target base.Datom) {
-	switch index {
-	case base.EAVT:
-		switch len(components) {
-		case 4:
-			target.T = ResolveEntid(components[3])
-			fallthrough
-		case 3:
-			target.V = components[2] // resolveValue?
-			fallthrough
-		case 2:
-			target.A = ResolveEntid(components[1])
-			fallthrough
-		case 1:
-			target.E = ResolveEntid(components[0])
+	if 4 < len(components) {
+		panic("datoms: too many components")
+	}
+	for i, c := range components {
+		switch order[index][i] {
+		case 'E':
+			target.E = ResolveEntid(c)
+		case 'A':
+			target.A = ResolveEntid(c)
+		case 'V':
+			target.V = c // resolveValue?
+		case 'T':
+			target.T = ResolveEntid(c)
 		}
-		return
+	}
+	return
+}
+
+var order = [...]string{
+	base.EAVT: "EAVT",
+	base.AEVT: "AEVT",
+	base.AVET: "AVET",
+	base.VAET: "VAET",
+}
+
+// Component is the i:th component of d in index order
+func Component(index base.Index, d base.Datom, i int) any {
+	switch order[index][i] {
+	case 'E':
+		return d.E
+	case 'A':
+		return d.A
+	case 'V':
+		return d.V
 	default:
-		panic("todo")
+		return d.T
 	}
 }
 
-func TakeWhile(index base.Index, components []any) func(d base.Datom) bool {
-	t := Target(index, components)
-	switch index {
-	case base.EAVT:
-		switch len(components) {
-		case 0:
-			return func(d base.Datom) bool { return true }
-		case 1:
-			return func(d base.Datom) bool { return CompareOrdered(d.E, t.E) == 0 }
-		case 2:
-			return func(d base.Datom) bool {
-				return CompareOrdered(d.E, t.E) == 0 && CompareOrdered(d.A, t.A) == 0
+// ComparePrefix compares the n leading components of index in history order.
+// Datoms that share a prefix with a target compare equal to the target.
+func ComparePrefix(index base.Index, n int) func(x, y base.Datom) int {
+	prefix := order[index][:n]
+	return func(x, y base.Datom) int {
+		for i := 0; i < len(prefix); i++ {
+			var c int
+			switch prefix[i] {
+			case 'E':
+				c = CompareOrdered(x.E, y.E)
+			case 'A':
+				c = CompareOrdered(x.A, y.A)
+			case 'V':
+				c = CompareValue(x.V, y.V)
+			case 'T':
+				c = CompareOrdered(y.T, x.T) // descending
+			}
+			if c != 0 {
+				return c
 			}
-		default:
-			panic("uh-oh")
 		}
-	default:
-		panic("uh-oh")
+		return 0
 	}
 }
+
+func TakeWhile(index base.Index, components []any) func(d base.Datom) bool {
+	var (
+		t   = Target(index, components)
+		cmp = ComparePrefix(index, len(components))
+	)
+	return func(d base.Datom) bool { return cmp(d, t) == 0 }
+}
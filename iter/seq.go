@@ -13,11 +13,16 @@ package iter
 // Seq is a standard iterator and can be thought of as “push iterator”, which push values to the yield function.
 type Seq[V any] func(yield func(v V) bool)
 
+// Seq2 is a standard iterator over pairs of values, such as key-value pairs.
+type Seq2[K, V any] func(yield func(k K, v V) bool)
+
 // Forward returns a forward iterator over a slice
 func Forward[V any](s []V) Seq[V] {
 	return func(yield func(v V) bool) {
 		for i := 0; i < len(s); i++ {
-			yield(s[i])
+			if !yield(s[i]) {
+				break
+			}
 		}
 	}
 }
@@ -25,8 +30,10 @@ func Forward[V any](s []V) Seq[V] {
 // Backward returns a backward iterator over a slice
 func Backward[V any](s []V) Seq[V] {
 	return func(yield func(v V) bool) {
-		for i := len(s) - 1; 0 < i; i-- {
-			yield(s[i])
+		for i := len(s) - 1; 0 <= i; i-- {
+			if !yield(s[i]) {
+				break
+			}
 		}
 	}
 }
@@ -47,7 +54,7 @@ func Filter[V any](seq Seq[V], pred func(v V) bool) Seq[V] {
 	return func(yield func(V) bool) {
 		seq(func(v V) bool {
 			if pred(v) {
-				yield(v)
+				return yield(v)
 			}
 			return true
 		})
@@ -58,14 +65,27 @@ func TakeWhile[V any](seq Seq[V], pred func(v V) bool) Seq[V] {
 	return func(yield func(V) bool) {
 		seq(func(v V) bool {
 			if pred(v) {
-				yield(v)
-				return true
+				return yield(v)
 			}
 			return false
 		})
 	}
 }
 
+// Take produces at most the first n values of a sequence
+func Take[V any](seq Seq[V], n int) Seq[V] {
+	return func(yield func(V) bool) {
+		if n <= 0 {
+			return
+		}
+		i := 0
+		seq(func(v V) bool {
+			i++
+			return yield(v) && i < n
+		})
+	}
+}
+
 // Slice concatenate all values in sequence to a slice
 func Slice[V any](seq Seq[V]) []V {
 	var tmp []V
@@ -32,3 +32,38 @@ func TestFilter(t *testing.T) {
 func TestSlice(t *testing.T) {
 	testutil.AreEqualSlice(t, []int{1, 2, 3}, iter.Slice(iter.Range(1, 4)))
 }
+
+func TestBackward(t *testing.T) {
+	testutil.AreEqualSlice(t, []int{3, 2, 1}, iter.Slice(iter.Backward([]int{1, 2, 3})))
+}
+
+func TestTake(t *testing.T) {
+	testutil.AreEqualSlice(t, []int{0, 1, 2}, iter.Slice(iter.Take(iter.Range(0, 10), 3)))
+	testutil.AreEqualSlice(t, []int{0, 1}, iter.Slice(iter.Take(iter.Range(0, 2), 3)))
+	testutil.AreEqual(t, 0, len(iter.Slice(iter.Take(iter.Range(0, 10), 0))))
+}
+
+func TestStop(t *testing.T) {
+	s := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	even := func(v int) bool { return v%2 == 0 }
+	small := func(v int) bool { return v < 8 }
+
+	seqs := map[string]iter.Seq[int]{
+		"Forward":   iter.Forward(s),
+		"Backward":  iter.Backward(s),
+		"Range":     iter.Range(0, 10),
+		"Filter":    iter.Filter(iter.Forward(s), even),
+		"TakeWhile": iter.TakeWhile(iter.Forward(s), small),
+	}
+
+	for name, seq := range seqs {
+		t.Run(name, func(t *testing.T) {
+			n := 0
+			seq(func(v int) bool {
+				n++
+				return n < 2
+			})
+			testutil.AreEqual(t, 2, n)
+		})
+	}
+}
@@ -0,0 +1,290 @@
+// In-memory storage
+package mem
+
+import (
+	"slices"
+
+	"github.com/leidegre/datoms/cow"
+	"github.com/leidegre/datoms/hash"
+	hamt "github.com/leidegre/datoms/immutable/hashmap"
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/internal/sort"
+	"github.com/leidegre/datoms/iter"
+)
+
+// Database is an immutable database value. Transacting against a database
+// value makes a new database value, the old one is left as is.
+type Database struct {
+	baseT, nextT int64
+	parts        database.Partitions
+	schema       *schema.Schema
+	log          []base.Datom    // transaction order
+	indexes      [4][]base.Datom // history order, VAET only has refs
+	stats        hamt.Persistent[int64, database.Stats]
+	asOf         int64
+	history      bool
+}
+
+func (db *Database) T() (int64, int64) { return db.baseT, db.nextT }
+
+func (db *Database) NextId(part int64) int64 { return db.parts.NextId(part) }
+
+func (db *Database) Schema() schema.Interface { return db.schema }
+
+func (db *Database) Stats(a int64) database.Stats {
+	stats, _ := db.stats.Get(a, hash.Uint64(uint64(a)))
+	return stats
+}
+
+func (db *Database) SeekDatoms(index base.Index, components ...any) iter.Seq[base.Datom] {
+	return func(yield func(d base.Datom) bool) {
+		c := db.Cursor(index)
+		for c.Seek(components...); c.Valid(); c.Next() {
+			if !yield(c.Key()) {
+				return
+			}
+		}
+	}
+}
+
+func (db *Database) Datoms(index base.Index, components ...any) iter.Seq[base.Datom] {
+	return iter.TakeWhile(db.SeekDatoms(index, components...), sort.TakeWhile(index, components))
+}
+
+func (db *Database) Cursor(index base.Index) database.Cursor {
+	return database.NewCursor(index, db.indexes[index], db.asOf, db.history)
+}
+
+// Log iterates over the datoms of the transactions from startT up to but not
+// including endT in transaction order. If endT is zero there's no upper bound.
+func (db *Database) Log(startT, endT int64) iter.Seq[base.Datom] {
+	i, _ := slices.BinarySearchFunc(db.log, startT, func(d base.Datom, t int64) int {
+		return sort.CompareOrdered(d.Tx(), t)
+	})
+	return iter.TakeWhile(iter.Forward(db.log[i:]), func(d base.Datom) bool {
+		return endT == 0 || d.Tx() < endT
+	})
+}
+
+func (db *Database) AsOf(t int64) database.Interface {
+	tmp := *db
+	tmp.asOf = t
+	return &tmp
+}
+
+func (db *Database) History() database.Interface {
+	tmp := *db
+	tmp.history = true
+	return &tmp
+}
+
+func (db *Database) With(txData []base.TxData) (database.Transaction, error) {
+	curr := *db
+	curr.asOf, curr.history = 0, false
+
+	baseT, nextT, data, tempIds, tempNames, err := database.Transact(&curr, txData)
+	if err != nil {
+		return database.Transaction{}, err
+	}
+
+	s := db.schema.With(data)
+
+	// Retracted datoms of :db/noHistory attributes are dropped when indexed
+	// and excised datoms are dropped from the indexes and the log
+	indexed, noHistory := database.NoHistory(s, data)
+	excised := database.Excised(s, data)
+
+	drop := func(data []base.Datom) []base.Datom {
+		if noHistory != nil {
+			data = slices.DeleteFunc(slices.Clone(data), noHistory)
+		}
+		if excised != nil {
+			data = slices.DeleteFunc(slices.Clone(data), excised)
+		}
+		return data
+	}
+
+	after := &Database{
+		baseT:  baseT,
+		nextT:  nextT,
+		parts:  db.parts.With(tempIds),
+		schema: s,
+		log:    cow.Append(drop(db.log), indexed...),
+	}
+
+	for index := range after.indexes {
+		after.indexes[index] = merge(drop(db.indexes[index]), indexable(s, base.Index(index), indexed), sort.CompareHistory(base.Index(index)))
+	}
+
+	if excised != nil {
+		after.stats = after.recount()
+	} else {
+		after.stats = after.count(db, data)
+	}
+
+	return database.Transaction{
+		DbBefore:  db,
+		DbAfter:   after,
+		TxData:    data,
+		TempIds:   tempIds,
+		TempNames: tempNames,
+	}, nil
+}
+
+// exists reports whether there's a current datom that starts with components
+func (db *Database) exists(index base.Index, components ...any) bool {
+	data := db.indexes[index]
+	i, _ := slices.BinarySearchFunc(data, sort.Target(index, components), sort.ComparePrefix(index, len(components)))
+	prefix := sort.TakeWhile(index, components)
+	for j := i; j < len(data) && prefix(data[j]); j++ {
+		// the first datom of every E, A and V is the latest
+		if data[j].Assertion() && (j == i || sort.CompareEAV(data[j-1], data[j]) != 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// count updates the attribute statistics with the datoms of a transaction
+func (db *Database) count(before *Database, data []base.Datom) hamt.Persistent[int64, database.Stats] {
+	type av struct {
+		a int64
+		v string
+	}
+
+	var (
+		m        = before.stats
+		entities = make(map[[2]int64]bool)
+		values   = make(map[av]bool)
+		key      = func(d base.Datom) av { return av{d.A, database.ValueKey(d.V)} }
+	)
+
+	update := func(a int64, fn func(stats *database.Stats)) {
+		h := hash.Uint64(uint64(a))
+		stats, _ := m.Get(a, h)
+		fn(&stats)
+		m = m.Set(a, h, stats)
+	}
+
+	for _, d := range data {
+		update(d.A, func(stats *database.Stats) {
+			if d.Assertion() {
+				stats.Datoms++
+			} else {
+				stats.Datoms--
+			}
+		})
+
+		if ea := [2]int64{d.E, d.A}; !entities[ea] {
+			entities[ea] = true
+			was, is := before.exists(base.EAVT, d.E, d.A), db.exists(base.EAVT, d.E, d.A)
+			update(d.A, func(stats *database.Stats) { stats.Entities += bool2int(is) - bool2int(was) })
+		}
+
+		if k := key(d); !values[k] {
+			values[k] = true
+			was, is := before.exists(base.AVET, d.A, d.V), db.exists(base.AVET, d.A, d.V)
+			update(d.A, func(stats *database.Stats) { stats.Values += bool2int(is) - bool2int(was) })
+		}
+	}
+
+	return m
+}
+
+// recount computes the attribute statistics from scratch
+func (db *Database) recount() (m hamt.Persistent[int64, database.Stats]) {
+	var (
+		stats database.Stats
+		prev  *base.Datom
+	)
+	flush := func() {
+		if prev != nil {
+			m = m.Set(prev.A, hash.Uint64(uint64(prev.A)), stats)
+		}
+		stats = database.Stats{}
+	}
+	db.Datoms(base.AVET)(func(d base.Datom) bool {
+		if prev == nil || prev.A != d.A {
+			flush()
+		}
+		stats.Datoms++
+		if prev == nil || prev.A != d.A || sort.CompareValue(prev.V, d.V) != 0 {
+			stats.Values++
+		}
+		prev = &d
+		return true
+	})
+	flush()
+	prev = nil
+	db.Datoms(base.AEVT)(func(d base.Datom) bool {
+		if prev == nil || prev.A != d.A || prev.E != d.E {
+			stats, _ := m.Get(d.A, hash.Uint64(uint64(d.A)))
+			stats.Entities++
+			m = m.Set(d.A, hash.Uint64(uint64(d.A)), stats)
+		}
+		prev = &d
+		return true
+	})
+	return m
+}
+
+func bool2int(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// indexable filters out the datoms that do not go in index
+func indexable(s schema.Interface, index base.Index, data []base.Datom) []base.Datom {
+	if index != base.VAET {
+		return data
+	}
+	refType, _ := s.Id(schema.DbTypeRef)
+	var tmp []base.Datom
+	for _, d := range data {
+		if attr, _ := s.Attr(d.A); attr.ValueType == refType {
+			tmp = append(tmp, d)
+		}
+	}
+	return tmp
+}
+
+// merge the sorted slice x with y, y doesn't need to be sorted
+func merge(x, y []base.Datom, cmp func(a, b base.Datom) int) []base.Datom {
+	y = slices.Clone(y)
+	slices.SortFunc(y, cmp)
+	tmp := make([]base.Datom, 0, len(x)+len(y))
+	for 0 < len(x) && 0 < len(y) {
+		if cmp(y[0], x[0]) < 0 {
+			tmp, y = append(tmp, y[0]), y[1:]
+		} else {
+			tmp, x = append(tmp, x[0]), x[1:]
+		}
+	}
+	tmp = append(tmp, x...)
+	return append(tmp, y...)
+}
+
+// New creates an empty database with just the bootstrapping part
+func New() *Database {
+	var (
+		db   Database
+		data = schema.BootstrappingPart(0)
+	)
+
+	db.baseT = 1000
+	db.nextT = 1000
+	db.schema = (&schema.Schema{}).With(data)
+	db.log = data
+
+	for index := range db.indexes {
+		db.indexes[index] = merge(nil, indexable(db.schema, base.Index(index), data), sort.CompareHistory(base.Index(index)))
+	}
+
+	db.stats = db.recount()
+
+	return &db
+}
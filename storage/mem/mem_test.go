@@ -0,0 +1,239 @@
+package mem_test
+
+import (
+	"testing"
+
+	"github.com/leidegre/datoms/internal/base"
+	"github.com/leidegre/datoms/internal/database"
+	"github.com/leidegre/datoms/internal/schema"
+	"github.com/leidegre/datoms/iter"
+	"github.com/leidegre/datoms/storage/mem"
+	"github.com/leidegre/datoms/symbol"
+	"github.com/leidegre/datoms/testutil"
+)
+
+var (
+	heartbeat = symbol.For(":test/heartbeat")
+)
+
+func transact(t *testing.T, db database.Interface, txData ...base.TxData) database.Transaction {
+	tx, err := db.With(txData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func newDatabase(t *testing.T) database.Interface {
+	attr := base.NewTempId(schema.DbPartDb)
+
+	return transact(t, mem.New(),
+		database.Add(attr, schema.DbIdent, heartbeat),
+		database.Add(attr, schema.DbValueType, schema.DbTypeLong),
+		database.Add(attr, schema.DbCardinality, schema.DbCardinalityOne),
+		database.Add(attr, schema.DbNoHistory, true),
+		database.Add(base.Entity{Ident: schema.DbPartDb}, schema.DbInstallAttribute, attr),
+	).DbAfter
+}
+
+func TestDatoms(t *testing.T) {
+	db := newDatabase(t)
+
+	e := base.NewTempId(schema.DbPartUser)
+
+	tx := transact(t, db, database.Add(e, schema.DbDoc, "foo"))
+
+	datoms := iter.Slice(tx.DbAfter.Datoms(base.EAVT, tx.TxData[1].E))
+	testutil.AreEqual(t, 1, len(datoms))
+	testutil.AreEqual(t, "foo", datoms[0].V.(string))
+
+	// VAET only has refs, values of other types are not comparable
+	partDb, _ := tx.DbAfter.Schema().Id(schema.DbPartDb)
+	installed := iter.Slice(tx.DbAfter.Datoms(base.VAET))
+	testutil.AreEqual(t, true, 0 < len(installed))
+	testutil.AreEqual(t, partDb, installed[0].E)
+}
+
+func TestNoHistory(t *testing.T) {
+	db := newDatabase(t)
+
+	attr, ok := db.Schema().AttrKeyword(heartbeat)
+	if !(ok && attr.NoHistory) {
+		t.Fatal("expected :db/noHistory attribute")
+	}
+
+	tx := transact(t, db, database.Add(base.NewTempId(schema.DbPartUser), heartbeat, int64(1)))
+
+	var (
+		e     = tx.TxData[1].E
+		first = tx.TxData[1].Tx()
+	)
+
+	tx = transact(t, tx.DbAfter, database.Add(base.Entity{Id: e}, schema.DbDoc, "foo"))
+	tx = transact(t, tx.DbAfter, database.Add(base.Entity{Id: e}, heartbeat, int64(2)))
+	tx = transact(t, tx.DbAfter, database.Add(base.Entity{Id: e}, heartbeat, int64(3)))
+	tx = transact(t, tx.DbAfter, database.Add(base.Entity{Id: e}, schema.DbDoc, "bar"))
+
+	db = tx.DbAfter
+
+	current := iter.Slice(db.Datoms(base.EAVT, e, attr.Id))
+	testutil.AreEqual(t, 1, len(current))
+	testutil.AreEqual(t, int64(3), current[0].V.(int64))
+
+	// only the current value is left in history
+	hist := iter.Slice(db.History().Datoms(base.EAVT, e, attr.Id))
+	testutil.AreEqual(t, 1, len(hist))
+	testutil.AreEqual(t, int64(3), hist[0].V.(int64))
+
+	// other attributes keep their history
+	doc, _ := db.Schema().Id(schema.DbDoc)
+	testutil.AreEqual(t, 3, len(iter.Slice(db.History().Datoms(base.EAVT, e, doc))))
+
+	testutil.AreEqual(t, 0, len(iter.Slice(db.AsOf(first).Datoms(base.EAVT, e, attr.Id))))
+	testutil.AreEqual(t, 1, len(iter.Slice(db.AsOf(first+2).Datoms(base.EAVT, e, doc))))
+}
+
+func TestAsOf(t *testing.T) {
+	db := newDatabase(t)
+
+	tx := transact(t, db, database.Add(base.NewTempId(schema.DbPartUser), schema.DbDoc, "foo"))
+
+	var (
+		e     = tx.TxData[1].E
+		first = tx.TxData[1].Tx()
+	)
+
+	tx = transact(t, tx.DbAfter, database.Add(base.Entity{Id: e}, schema.DbDoc, "bar"))
+
+	var (
+		curr = iter.Slice(tx.DbAfter.Datoms(base.EAVT, e))
+		asOf = iter.Slice(tx.DbAfter.AsOf(first).Datoms(base.EAVT, e))
+	)
+
+	testutil.AreEqual(t, 1, len(curr))
+	testutil.AreEqual(t, "bar", curr[0].V.(string))
+	testutil.AreEqual(t, 1, len(asOf))
+	testutil.AreEqual(t, "foo", asOf[0].V.(string))
+}
+
+func TestExcise(t *testing.T) {
+	db := newDatabase(t)
+
+	tx := transact(t, db,
+		database.Add(base.NewTempId(schema.DbPartUser), schema.DbDoc, "secret"),
+		database.Add(base.NewTempId(schema.DbPartUser), schema.DbDoc, "public"))
+
+	var (
+		e1    = tx.TxData[1].E
+		e2    = tx.TxData[2].E
+		first = tx.TxData[1].Tx()
+	)
+
+	tx = transact(t, tx.DbAfter, database.Add(base.Entity{Id: e1}, heartbeat, int64(1)))
+	tx = transact(t, tx.DbAfter, database.Add(base.Entity{Id: e1}, schema.DbDoc, "also secret"))
+	tx = transact(t, tx.DbAfter, database.Excise(base.Entity{Id: e1}, schema.DbDoc))
+
+	db = tx.DbAfter
+
+	doc, _ := db.Schema().Id(schema.DbDoc)
+
+	testutil.AreEqual(t, 0, len(iter.Slice(db.Datoms(base.EAVT, e1, doc))))
+	testutil.AreEqual(t, 0, len(iter.Slice(db.History().Datoms(base.EAVT, e1, doc))))
+	testutil.AreEqual(t, 0, len(iter.Slice(db.AsOf(first).Datoms(base.EAVT, e1, doc))))
+	testutil.AreEqual(t, 1, len(iter.Slice(db.Datoms(base.EAVT, e1))))
+	testutil.AreEqual(t, 1, len(iter.Slice(db.Datoms(base.EAVT, e2))))
+
+	for _, d := range iter.Slice(db.(*mem.Database).Log(0, 0)) {
+		if d.E == e1 && d.A == doc {
+			t.Fatalf("excised datom %v is still in the log", d)
+		}
+	}
+
+	// the excision is recorded for audit
+	excise, _ := db.Schema().Id(schema.DbExcise)
+	excisions := iter.Slice(db.Datoms(base.AEVT, excise))
+	testutil.AreEqual(t, 1, len(excisions))
+	testutil.AreEqual(t, e1, excisions[0].V.(int64))
+}
+
+func TestExciseBefore(t *testing.T) {
+	db := newDatabase(t)
+
+	tx := transact(t, db, database.Add(base.NewTempId(schema.DbPartUser), schema.DbDoc, "foo"))
+	e1 := tx.TxData[1].E
+
+	tx = transact(t, tx.DbAfter, database.Add(base.NewTempId(schema.DbPartUser), schema.DbDoc, "bar"))
+	e2, second := tx.TxData[1].E, tx.TxData[1].Tx()
+
+	tx = transact(t, tx.DbAfter, database.ExciseBefore(base.Entity{Ident: schema.DbDoc}, second))
+
+	db = tx.DbAfter
+
+	doc, _ := db.Schema().Id(schema.DbDoc)
+
+	testutil.AreEqual(t, 0, len(iter.Slice(db.History().Datoms(base.EAVT, e1, doc))))
+	testutil.AreEqual(t, 1, len(iter.Slice(db.History().Datoms(base.EAVT, e2, doc))))
+}
+
+func TestStats(t *testing.T) {
+	db := newDatabase(t)
+
+	doc, _ := db.Schema().Id(schema.DbDoc)
+
+	stats := func(db database.Interface) database.Stats {
+		s := db.(database.Statistics).Stats(doc)
+		s0 := newDatabase(t).(database.Statistics).Stats(doc)
+		return database.Stats{Datoms: s.Datoms - s0.Datoms, Values: s.Values - s0.Values, Entities: s.Entities - s0.Entities}
+	}
+
+	tx := transact(t, db,
+		database.Add(base.NewTempId(schema.DbPartUser), schema.DbDoc, "a"),
+		database.Add(base.NewTempId(schema.DbPartUser), schema.DbDoc, "a"),
+		database.Add(base.NewTempId(schema.DbPartUser), schema.DbDoc, "b"))
+	testutil.AreEqual(t, database.Stats{Datoms: 3, Values: 2, Entities: 3}, stats(tx.DbAfter))
+
+	var (
+		e1 = tx.TxData[1].E
+		e2 = tx.TxData[2].E
+	)
+
+	// implicit retraction of "a"
+	tx = transact(t, tx.DbAfter, database.Add(base.Entity{Id: e1}, schema.DbDoc, "c"))
+	testutil.AreEqual(t, database.Stats{Datoms: 3, Values: 3, Entities: 3}, stats(tx.DbAfter))
+
+	tx = transact(t, tx.DbAfter, database.Excise(base.Entity{Id: e2}, schema.DbDoc))
+	testutil.AreEqual(t, database.Stats{Datoms: 2, Values: 2, Entities: 2}, stats(tx.DbAfter))
+
+	tx = transact(t, tx.DbAfter, database.Retract(base.Entity{Id: e1}, schema.DbDoc, "c"))
+	testutil.AreEqual(t, database.Stats{Datoms: 1, Values: 1, Entities: 1}, stats(tx.DbAfter))
+}
+
+func TestCursor(t *testing.T) {
+	db := newDatabase(t)
+
+	var txData []base.TxData
+	for _, doc := range []string{"a", "b", "c", "d"} {
+		txData = append(txData, database.Add(base.NewTempId(schema.DbPartUser), schema.DbDoc, doc))
+	}
+	tx := transact(t, db, txData...)
+	first := tx.TxData[1].Tx()
+	c := tx.TxData[3].E
+
+	// "c" is no longer current
+	tx = transact(t, tx.DbAfter, database.Retract(base.Entity{Id: c}, schema.DbDoc, "c"))
+
+	doc, _ := tx.DbAfter.Schema().Id(schema.DbDoc)
+
+	values := func(db database.Interface) []string {
+		var s []string
+		cur := db.Cursor(base.AVET)
+		for cur.Seek(doc, "b"); cur.Valid() && cur.Key().A == doc; cur.Next() {
+			s = append(s, cur.Key().V.(string))
+		}
+		return s
+	}
+
+	testutil.AreEqualSlice(t, []string{"b", "d"}, values(tx.DbAfter))
+	testutil.AreEqualSlice(t, []string{"b", "c", "d"}, values(tx.DbAfter.AsOf(first)))
+	testutil.AreEqualSlice(t, []string{"b", "c", "c", "d"}, values(tx.DbAfter.History()))
+}